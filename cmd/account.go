@@ -0,0 +1,329 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const profilesFileName = "profiles.json"
+
+// profileSettings are the defaults a profile sets for flags not given on
+// the command line.
+type profileSettings struct {
+	Dst     string   `json:"dst,omitempty"`
+	Jobs    int      `json:"jobs,omitempty"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	Only    []string `json:"only,omitempty"`
+}
+
+// profileConfig is the profiles file. The tokens of the profiles are kept in
+// the token file, under the profile name.
+type profileConfig struct {
+	Default  string                      `json:"default,omitempty"`
+	Profiles map[string]*profileSettings `json:"profiles,omitempty"`
+}
+
+var (
+	profileFlag string
+	// profileName is the profile the running command uses.
+	profileName = tokenPersonal
+	profile     = &profileSettings{}
+)
+
+// readProfiles reads the profiles file. A missing file has no profiles.
+func readProfiles(filePath string) (c *profileConfig, err error) {
+	c = &profileConfig{}
+	b, err := ioutil.ReadFile(filePath)
+	switch {
+	case os.IsNotExist(err):
+		err = nil
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(b, c); err != nil {
+			return nil, fmt.Errorf("%s: %v", filePath, err)
+		}
+	}
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*profileSettings)
+	}
+	return
+}
+
+// current returns the profile chosen with --profile, or else the default.
+func (c *profileConfig) current() string {
+	switch {
+	case profileFlag != "":
+		return profileFlag
+	case c.Default != "":
+		return c.Default
+	}
+	return tokenPersonal
+}
+
+// setDefault sets flag on cmd to value unless it was given on the command
+// line.
+func setDefault(cmd *cobra.Command, flag string, value string) error {
+	f := cmd.Flags().Lookup(flag)
+	if f == nil || f.Changed || value == "" {
+		return nil
+	}
+	return f.Value.Set(value)
+}
+
+// apply sets the flags of cmd the profile has defaults for.
+func (s *profileSettings) apply(cmd *cobra.Command) (err error) {
+	if s.Jobs > 0 {
+		if err = setDefault(cmd, "jobs", strconv.Itoa(s.Jobs)); err != nil {
+			return
+		}
+	}
+	if err = setDefault(cmd, "include", strings.Join(s.Include, ",")); err != nil {
+		return
+	}
+	if err = setDefault(cmd, "exclude", strings.Join(s.Exclude, ",")); err != nil {
+		return
+	}
+	return setDefault(cmd, "only", strings.Join(s.Only, ","))
+}
+
+// defaultDst is where files go when no destination is given.
+func defaultDst() string {
+	if profile.Dst != "" {
+		return profile.Dst
+	}
+	return "/"
+}
+
+// accountFiles returns the paths of the token file and the profiles file.
+func accountFiles() (tokenPath string, profilesPath string, err error) {
+	dir, err := configDir()
+	if err != nil {
+		return
+	}
+	return path.Join(dir, configFileName), path.Join(dir, profilesFileName), nil
+}
+
+func loadAccounts() (tokenMap TokenMap, profiles *profileConfig, err error) {
+	tokenPath, profilesPath, err := accountFiles()
+	if err != nil {
+		return
+	}
+
+	if tokenMap, err = readTokens(tokenPath); err != nil {
+		return
+	}
+	if tokenMap == nil {
+		tokenMap = make(TokenMap)
+	}
+	if tokenMap[""] == nil {
+		tokenMap[""] = make(map[string]*storedToken)
+	}
+	profiles, err = readProfiles(profilesPath)
+	return
+}
+
+func saveAccounts(tokenMap TokenMap, profiles *profileConfig) error {
+	tokenPath, profilesPath, err := accountFiles()
+	if err != nil {
+		return err
+	}
+	if err = writeTokens(tokenPath, tokenMap); err != nil {
+		return err
+	}
+	return writeJSONFile(profilesPath, profiles)
+}
+
+func accountList(cmd *cobra.Command, args []string) (err error) {
+	tokenMap, profiles, err := loadAccounts()
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for name := range tokenMap[""] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	current := profiles.current()
+	for _, name := range names {
+		mark := " "
+		if name == current {
+			mark = "*"
+		}
+		fmt.Printf("%s %s", mark, name)
+		if s := profiles.Profiles[name]; s != nil {
+			if s.Dst != "" {
+				fmt.Printf("  dst=%s", s.Dst)
+			}
+			if s.Jobs > 0 {
+				fmt.Printf("  jobs=%d", s.Jobs)
+			}
+			if len(s.Include) > 0 {
+				fmt.Printf("  include=%s", strings.Join(s.Include, ","))
+			}
+			if len(s.Exclude) > 0 {
+				fmt.Printf("  exclude=%s", strings.Join(s.Exclude, ","))
+			}
+			if len(s.Only) > 0 {
+				fmt.Printf("  only=%s", strings.Join(s.Only, ","))
+			}
+		}
+		fmt.Println()
+	}
+	if len(names) == 0 {
+		fmt.Println("no accounts, add one with `boxit account add <name>`")
+	}
+	return
+}
+
+var addSettings profileSettings
+
+func accountAdd(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("`account add` requires a `name` argument")
+	}
+	name := args[0]
+
+	tokenMap, profiles, err := loadAccounts()
+	if err != nil {
+		return
+	}
+
+	tokens := tokenMap[""]
+	if tokens[name] == nil {
+		token, err := login()
+		if err != nil {
+			return err
+		}
+		tokens[name] = (*storedToken)(token)
+	}
+
+	s := profiles.Profiles[name]
+	if s == nil {
+		s = &profileSettings{}
+		profiles.Profiles[name] = s
+	}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch f.Name {
+		case "dst":
+			s.Dst = addSettings.Dst
+		case "jobs":
+			s.Jobs = addSettings.Jobs
+		case "include":
+			s.Include = addSettings.Include
+		case "exclude":
+			s.Exclude = addSettings.Exclude
+		case "only":
+			s.Only = addSettings.Only
+		}
+	})
+	if len(tokens) == 1 {
+		profiles.Default = name
+	}
+
+	if err = saveAccounts(tokenMap, profiles); err != nil {
+		return
+	}
+	fmt.Printf("added %s\n", name)
+	return
+}
+
+func accountRemove(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("`account remove` requires a `name` argument")
+	}
+	name := args[0]
+
+	tokenMap, profiles, err := loadAccounts()
+	if err != nil {
+		return
+	}
+	if _, ok := tokenMap[""][name]; !ok {
+		return fmt.Errorf("no account named %s", name)
+	}
+
+	delete(tokenMap[""], name)
+	delete(profiles.Profiles, name)
+	if profiles.Default == name {
+		profiles.Default = ""
+	}
+
+	if err = saveAccounts(tokenMap, profiles); err != nil {
+		return
+	}
+	fmt.Printf("removed %s\n", name)
+	return
+}
+
+func accountDefault(cmd *cobra.Command, args []string) (err error) {
+	tokenMap, profiles, err := loadAccounts()
+	if err != nil {
+		return
+	}
+
+	if len(args) == 0 {
+		fmt.Println(profiles.current())
+		return
+	}
+
+	name := args[0]
+	if _, ok := tokenMap[""][name]; !ok {
+		return fmt.Errorf("no account named %s", name)
+	}
+	profiles.Default = name
+	return saveAccounts(tokenMap, profiles)
+}
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage Dropbox accounts and their profiles",
+	// Account management works on the local token file only.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+}
+
+func init() {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the accounts, marking the one in use",
+		RunE:  accountList,
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Log in to a Dropbox account and save it as a profile",
+		RunE:  accountAdd,
+	}
+	addCmd.Flags().StringVar(&addSettings.Dst, "dst", "", "default destination for upload and watch")
+	addCmd.Flags().IntVarP(&addSettings.Jobs, "jobs", "j", 0, "default number of files to transfer in parallel")
+	addCmd.Flags().StringSliceVar(&addSettings.Include, "include", nil, "default --include globs")
+	addCmd.Flags().StringSliceVar(&addSettings.Exclude, "exclude", nil, "default --exclude globs")
+	addCmd.Flags().StringSliceVar(&addSettings.Only, "only", nil, "default --only kinds")
+
+	removeCmd := &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Forget an account",
+		RunE:  accountRemove,
+	}
+
+	defaultCmd := &cobra.Command{
+		Use:   "default [name]",
+		Short: "Show or set the account used without --profile",
+		RunE:  accountDefault,
+	}
+
+	accountCmd.AddCommand(listCmd, addCmd, removeCmd, defaultCmd)
+	RootCmd.AddCommand(accountCmd)
+}
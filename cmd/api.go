@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+)
+
+// remoteFile is the subset of the Dropbox file metadata boxit works with.
+// The vendored SDK predates content_hash, so these calls go through rpc.
+type remoteFile struct {
+	Tag            string    `json:".tag"`
+	Name           string    `json:"name"`
+	PathLower      string    `json:"path_lower"`
+	PathDisplay    string    `json:"path_display"`
+	Id             string    `json:"id"`
+	Rev            string    `json:"rev"`
+	Size           uint64    `json:"size"`
+	ContentHash    string    `json:"content_hash"`
+	ClientModified time.Time `json:"client_modified"`
+}
+
+type listFolderResult struct {
+	Entries []*remoteFile `json:"entries"`
+	Cursor  string        `json:"cursor"`
+	HasMore bool          `json:"has_more"`
+}
+
+// rpc calls a files/ RPC endpoint with arg and decodes the response into
+// res, retrying transient failures.
+func rpc(route string, arg interface{}, res interface{}) (err error) {
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return
+	}
+
+	return retry(route, func() error {
+		return rpcOnce(route, b, res)
+	})
+}
+
+func rpcOnce(route string, arg []byte, res interface{}) (err error) {
+	ctx := dropbox.NewContext(config)
+
+	req, err := http.NewRequest("POST", ctx.GenerateURL("api", "files", route), bytes.NewReader(arg))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ctx.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiError dropbox.APIError
+		if json.Unmarshal(body, &apiError) != nil || apiError.ErrorSummary == "" {
+			apiError.ErrorSummary = string(body)
+		}
+		return apiError
+	}
+	return json.Unmarshal(body, res)
+}
+
+// apiArg encodes arg for the Dropbox-API-Arg header, which only accepts
+// ASCII, so other characters are escaped as \uXXXX.
+func apiArg(arg interface{}) (string, error) {
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, r := range string(b) {
+		switch {
+		case r < utf8.RuneSelf:
+			buf.WriteRune(r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&buf, "\\u%04x\\u%04x", r1, r2)
+		default:
+			fmt.Fprintf(&buf, "\\u%04x", r)
+		}
+	}
+	return buf.String(), nil
+}
+
+// download returns the contents of the file at src starting at offset.
+// partial is false when the server sent the whole file despite the range.
+func download(src string, offset int64) (body io.ReadCloser, partial bool, err error) {
+	ctx := dropbox.NewContext(config)
+
+	arg, err := apiArg(map[string]string{"path": src})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest("POST", ctx.GenerateURL("content", "files", "download"), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Dropbox-API-Arg", arg)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := ctx.Client.Do(req)
+	if err != nil {
+		return
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, false, nil
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+	}
+
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	var apiError dropbox.APIError
+	if json.Unmarshal(b, &apiError) != nil || apiError.ErrorSummary == "" {
+		apiError.ErrorSummary = string(b)
+	}
+	return nil, false, apiError
+}
+
+// isNotFound reports whether err is a path/not_found lookup error.
+func isNotFound(err error) bool {
+	e, ok := err.(dropbox.APIError)
+	return ok && strings.Contains(e.ErrorSummary, "not_found")
+}
+
+// getRemoteFile returns the metadata of the file at dst, or nil if there is
+// nothing at dst.
+func getRemoteFile(dst string) (*remoteFile, error) {
+	var res remoteFile
+	err := rpc("get_metadata", map[string]string{"path": dst}, &res)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// listFolder lists everything below root recursively, or the changes since
+// cursor when it is set, and returns the cursor to continue from.
+func listFolder(root string, cursor string) (entries []*remoteFile, next string, err error) {
+	return listEntries(root, true, cursor)
+}
+
+// listDir returns the files and folders directly in dir. A missing dir
+// yields no entries.
+func listDir(dir string) (entries []*remoteFile, err error) {
+	entries, _, err = listEntries(dir, false, "")
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return
+}
+
+func listEntries(root string, recursive bool, cursor string) (entries []*remoteFile, next string, err error) {
+	if root == "/" {
+		root = ""
+	}
+
+	var res listFolderResult
+	if cursor == "" {
+		err = rpc("list_folder", map[string]interface{}{"path": root, "recursive": recursive}, &res)
+	} else {
+		err = rpc("list_folder/continue", map[string]string{"cursor": cursor}, &res)
+	}
+
+	for err == nil {
+		entries = append(entries, res.Entries...)
+		if !res.HasMore {
+			return entries, res.Cursor, nil
+		}
+
+		cursor := res.Cursor
+		res = listFolderResult{}
+		err = rpc("list_folder/continue", map[string]string{"cursor": cursor}, &res)
+	}
+	return
+}
+
+// listRemoteFiles returns every file below root, recursively. A missing
+// root yields no entries.
+func listRemoteFiles(root string) (files []*remoteFile, err error) {
+	entries, _, err := listFolder(root, "")
+	if isNotFound(err) {
+		return nil, nil
+	}
+
+	for _, e := range entries {
+		if e.Tag == "file" {
+			files = append(files, e)
+		}
+	}
+	return
+}
@@ -0,0 +1,447 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/auth"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/users"
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+const (
+	authorizeURL = "https://www.dropbox.com/oauth2/authorize"
+	tokenURL     = "https://api.dropboxapi.com/oauth2/token"
+
+	// The redirect URI registered for the app, on which login listens for
+	// the browser to come back with the code.
+	redirectAddr = "127.0.0.1:53682"
+	redirectURI  = "http://localhost:53682/"
+	loginTimeout = 5 * time.Minute
+)
+
+var tokenClient = &http.Client{Timeout: time.Minute}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// headless reports whether there is no browser to open, as on a server or
+// over ssh.
+func headless() bool {
+	if os.Getenv("SSH_CONNECTION") != "" {
+		return true
+	}
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return false
+	}
+	return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+}
+
+func openBrowser(u string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Start()
+	}
+	return exec.Command("xdg-open", u).Start()
+}
+
+// authURL returns the authorize URL for the PKCE flow. Without a redirect
+// Dropbox shows the code for the user to paste.
+func authURL(challenge string, state string, redirect string) string {
+	v := url.Values{
+		"client_id":             {clientID()},
+		"response_type":         {"code"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"token_access_type":     {"offline"},
+	}
+	if redirect != "" {
+		v.Set("redirect_uri", redirect)
+		v.Set("state", state)
+	}
+	return authorizeURL + "?" + v.Encode()
+}
+
+// receiveCode serves the redirect on ln until the browser comes back with
+// the code for state.
+func receiveCode(ln net.Listener, state string) (code string, err error) {
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+
+		res := result{code: q.Get("code")}
+		if e := q.Get("error"); e != "" {
+			res.err = fmt.Errorf("authorization failed: %s %s", e, q.Get("error_description"))
+			fmt.Fprintln(w, "boxit was not authorized. You can close this window.")
+		} else {
+			fmt.Fprintln(w, "boxit is authorized. You can close this window.")
+		}
+		select {
+		case done <- res:
+		default:
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	select {
+	case res := <-done:
+		return res.code, res.err
+	case <-time.After(loginTimeout):
+		return "", errors.New("timed out waiting for the browser to authorize boxit")
+	}
+}
+
+// tokenResponse is the reply of the token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func postToken(form url.Values) (token *oauth2.Token, err error) {
+	form.Set("client_id", clientID())
+	resp, err := tokenClient.PostForm(tokenURL, form)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var res tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	if res.Error != "" || res.AccessToken == "" {
+		return nil, fmt.Errorf("token request failed: %s %s", res.Error, res.ErrorDescription)
+	}
+
+	token = &oauth2.Token{
+		AccessToken:  res.AccessToken,
+		TokenType:    res.TokenType,
+		RefreshToken: res.RefreshToken,
+	}
+	if res.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+	return
+}
+
+// refreshToken gets a new access token for t. Dropbox keeps the refresh
+// token the same, so it is carried over.
+func refreshToken(t *oauth2.Token) (token *oauth2.Token, err error) {
+	token, err = postToken(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {t.RefreshToken},
+	})
+	if err == nil && token.RefreshToken == "" {
+		token.RefreshToken = t.RefreshToken
+	}
+	return
+}
+
+// login authorizes boxit with a Dropbox account using OAuth 2 with PKCE, so
+// no app secret is needed. The browser is sent back to a listener on
+// localhost; where there is no browser, or the port is taken, the user
+// pastes the code Dropbox shows instead.
+func login() (token *oauth2.Token, err error) {
+	if !interactive() {
+		return nil, errNonInteractive
+	}
+
+	verifier, err := randomString(32)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	state, err := randomString(16)
+	if err != nil {
+		return
+	}
+
+	var code, redirect string
+	var ln net.Listener
+	if !headless() {
+		ln, _ = net.Listen("tcp", redirectAddr)
+	}
+
+	if ln != nil {
+		redirect = redirectURI
+		u := authURL(challenge, state, redirect)
+		fmt.Printf("Opening %v\n", u)
+		if openBrowser(u) != nil {
+			fmt.Println("Open the address above in a browser to authorize boxit.")
+		}
+		code, err = receiveCode(ln, state)
+	} else {
+		fmt.Printf("Go to %v\nand paste the code here: ", authURL(challenge, "", ""))
+		_, err = fmt.Scan(&code)
+	}
+	if err != nil {
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {strings.TrimSpace(code)},
+		"code_verifier": {verifier},
+	}
+	if redirect != "" {
+		form.Set("redirect_uri", redirect)
+	}
+	return postToken(form)
+}
+
+// tokenSource hands out the access token of the current profile, renewing
+// it with the refresh token when it expires and writing the new token back
+// to the token file. Without a profile, as for a token from the
+// environment, renewed tokens are only kept in memory.
+type tokenSource struct {
+	mu      sync.Mutex
+	profile string
+	token   *oauth2.Token
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	return s.refresh()
+}
+
+// refresh renews the token. s.mu must be held.
+func (s *tokenSource) refresh() (token *oauth2.Token, err error) {
+	if s.token.RefreshToken == "" {
+		return nil, errors.New("the access token expired, log in again")
+	}
+	if token, err = refreshToken(s.token); err != nil {
+		return
+	}
+	s.token = token
+	if s.profile == "" {
+		return token, nil
+	}
+	return token, saveToken(s.profile, token)
+}
+
+// invalidate renews the token unless that already happened since stale was
+// handed out, after the server rejected stale.
+func (s *tokenSource) invalidate(stale *oauth2.Token) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != stale {
+		return s.token, nil
+	}
+	return s.refresh()
+}
+
+// saveToken stores the token of profile in the token file, keeping the
+// other entries as they are now on disk.
+func saveToken(profile string, token *oauth2.Token) error {
+	tokenPath, _, err := accountFiles()
+	if err != nil {
+		return err
+	}
+	tokenMap, err := readTokens(tokenPath)
+	if err != nil {
+		return err
+	}
+	if tokenMap == nil {
+		tokenMap = make(TokenMap)
+	}
+	if tokenMap[""] == nil {
+		tokenMap[""] = make(map[string]*storedToken)
+	}
+	tokenMap[""][profile] = (*storedToken)(token)
+	return writeTokens(tokenPath, tokenMap)
+}
+
+// authTransport puts the current access token on every Dropbox request,
+// replacing the one the SDK was configured with, and retries a request once
+// with a renewed token when the server says it expired.
+type authTransport struct {
+	base   http.RoundTripper
+	source *tokenSource
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		return t.base.RoundTrip(req)
+	}
+
+	token, err := t.source.Token()
+	if err != nil {
+		return
+	}
+	if resp, err = t.base.RoundTrip(withToken(req, token)); err != nil {
+		return
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized || token.RefreshToken == "" {
+		return
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return
+	}
+
+	resp.Body.Close()
+	if token, err = t.source.invalidate(token); err != nil {
+		return nil, err
+	}
+	retry := withToken(req, token)
+	if req.Body != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+func withToken(req *http.Request, token *oauth2.Token) *http.Request {
+	r := req.Clone(req.Context())
+	token.SetAuthHeader(r)
+	return r
+}
+
+func authLogin(cmd *cobra.Command, args []string) (err error) {
+	tokenMap, profiles, err := loadAccounts()
+	if err != nil {
+		return
+	}
+
+	// Without --profile the token is the personal one, whatever the default
+	// profile is.
+	name := profileFlag
+	if name == "" {
+		name = tokenPersonal
+	}
+	token, err := login()
+	if err != nil {
+		return
+	}
+	tokenMap[""][name] = (*storedToken)(token)
+	if len(tokenMap[""]) == 1 && name != tokenPersonal {
+		profiles.Default = name
+	}
+
+	if err = saveAccounts(tokenMap, profiles); err != nil {
+		return
+	}
+	fmt.Printf("logged in as %s\n", name)
+	return
+}
+
+func authStatus(cmd *cobra.Command, args []string) (err error) {
+	account, err := users.New(config).GetCurrentAccount()
+	if err != nil {
+		return
+	}
+
+	if tokenOrigin != "" {
+		fmt.Printf("token:    from %s\n", tokenOrigin)
+	} else {
+		fmt.Printf("profile:  %s\n", profileName)
+	}
+	if account.Name != nil {
+		fmt.Printf("name:     %s\n", account.Name.DisplayName)
+	}
+	fmt.Printf("email:    %s\n", account.Email)
+	if account.AccountType != nil {
+		fmt.Printf("type:     %s\n", account.AccountType.Tag)
+	}
+	if account.Team != nil {
+		fmt.Printf("team:     %s\n", account.Team.Name)
+	}
+	return
+}
+
+func authLogout(cmd *cobra.Command, args []string) (err error) {
+	if err = auth.New(config).TokenRevoke(); err != nil {
+		return fmt.Errorf("could not revoke the token: %v, `boxit account remove %s` forgets it anyway", err, profileName)
+	}
+	if tokenOrigin != "" {
+		fmt.Printf("revoked the token from %s\n", tokenOrigin)
+		return
+	}
+
+	tokenMap, profiles, err := loadAccounts()
+	if err != nil {
+		return
+	}
+	delete(tokenMap[""], profileName)
+	if err = saveAccounts(tokenMap, profiles); err != nil {
+		return
+	}
+	fmt.Printf("logged out of %s\n", profileName)
+	return
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Log in to and out of Dropbox",
+}
+
+func init() {
+	loginCmd := &cobra.Command{
+		Use:   "login",
+		Short: "Authorize boxit with a Dropbox account",
+		RunE:  authLogin,
+		// Logging in is what makes the token the other commands need.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the Dropbox account boxit uses",
+		RunE:  authStatus,
+	}
+
+	logoutCmd := &cobra.Command{
+		Use:     "logout",
+		Aliases: []string{"revoke"},
+		Short:   "Revoke boxit's access to the Dropbox account and forget the token",
+		RunE:    authLogout,
+	}
+
+	authCmd.AddCommand(loginCmd, statusCmd, logoutCmd)
+	RootCmd.AddCommand(authCmd)
+}
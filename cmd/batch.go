@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+const (
+	// batchSize is the most entries UploadSessionFinishBatch accepts.
+	batchSize         = 1000
+	batchPollInterval = time.Second
+)
+
+type finishBatchArg struct {
+	Entries []*files.UploadSessionFinishArg `json:"entries"`
+}
+
+type finishBatchEntry struct {
+	remoteFile
+	Failure *files.UploadSessionFinishError `json:"failure"`
+}
+
+// finishBatchResult decodes both upload_session/finish_batch and its check
+// route. The vendored SDK drops the async_job_id of the launch response,
+// so these go through rpc.
+type finishBatchResult struct {
+	Tag        string              `json:".tag"`
+	AsyncJobID string              `json:"async_job_id"`
+	Entries    []*finishBatchEntry `json:"entries"`
+}
+
+func failureReason(f *files.UploadSessionFinishError) string {
+	switch {
+	case f == nil:
+		return "unknown error"
+	case f.LookupFailed != nil:
+		return f.Tag + "/" + f.LookupFailed.Tag
+	case f.Path != nil:
+		return f.Tag + "/" + f.Path.Tag
+	}
+	return f.Tag
+}
+
+// finishBatch commits staged in one UploadSessionFinishBatch job and waits
+// for it, at most --retry-timeout. The returned entries line up with staged.
+func finishBatch(staged []*stagedFile) (entries []*finishBatchEntry, err error) {
+	var arg finishBatchArg
+	for _, s := range staged {
+		arg.Entries = append(arg.Entries, files.NewUploadSessionFinishArg(s.cursor, s.commit))
+	}
+
+	var res finishBatchResult
+	if err = rpc("upload_session/finish_batch", arg, &res); err != nil {
+		return
+	}
+
+	jobID := res.AsyncJobID
+	deadline := time.Now().Add(retryTimeout)
+	for res.Tag == "async_job_id" || res.Tag == "in_progress" {
+		if retryTimeout > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("batch commit still in progress after %s", retryTimeout)
+		}
+		time.Sleep(batchPollInterval)
+
+		res = finishBatchResult{}
+		err = rpc("upload_session/finish_batch/check", map[string]string{"async_job_id": jobID}, &res)
+		if err != nil {
+			return
+		}
+	}
+
+	if res.Tag != "complete" {
+		return nil, fmt.Errorf("batch commit failed: %s", res.Tag)
+	}
+	if len(res.Entries) != len(staged) {
+		return nil, fmt.Errorf("batch commit returned %d results for %d files", len(res.Entries), len(staged))
+	}
+	return res.Entries, nil
+}
+
+// commitStaged commits staged files in batches and returns one result per
+// file. Committed files whose content hash differs from the local one are
+// reported as failed. Entries refused with too_many_write_operations are committed again
+// in the next batch.
+func commitStaged(staged []*stagedFile) (results []uploadResult) {
+	for attempt := 0; len(staged) > 0; attempt++ {
+		var again []*stagedFile
+
+		for len(staged) > 0 {
+			n := len(staged)
+			if n > batchSize {
+				n = batchSize
+			}
+			batch := staged[:n]
+			staged = staged[n:]
+
+			entries, err := finishBatch(batch)
+			for i, s := range batch {
+				result := uploadResult{task: s.task, err: err}
+				if err == nil {
+					if e := entries[i]; e.Tag == "success" {
+						file := e.remoteFile
+						result.file = &file
+						journal.forget(s.key)
+						if file.ContentHash != s.hash {
+							result.err = fmt.Errorf("content hash of %s does not match the local file", file.PathDisplay)
+						}
+					} else {
+						reason := failureReason(e.Failure)
+						if strings.Contains(reason, "too_many_write_operations") && attempt < maxRetries {
+							again = append(again, s)
+							continue
+						}
+						if e.Failure != nil && e.Failure.LookupFailed != nil {
+							journal.forget(s.key)
+						}
+						result.err = fmt.Errorf("commit failed: %s", reason)
+					}
+				}
+				results = append(results, result)
+			}
+		}
+
+		if len(again) > 0 {
+			wait := backoff(attempt)
+			logf(os.Stderr, "%d files hit too_many_write_operations, retrying in %s\n", len(again), wait)
+			time.Sleep(wait)
+		}
+		staged = again
+	}
+	return
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// canonUUID marks the box holding the Exif data of Canon CR3 files.
+var canonUUID = mustHex("85c0b687820f11e08111f4ce462b6a48")
+
+// mp4Epoch is the start of the creation_time clock in MP4 and QuickTime.
+var mp4Epoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// box is a box of the ISO base media file format used by MP4, MOV and CR3.
+type box struct {
+	typ  string
+	data int64
+	end  int64
+}
+
+// findBox returns the first box of type typ between start and end.
+func findBox(r io.ReaderAt, start, end int64, typ string) (b box, ok bool) {
+	var header [16]byte
+	for pos := start; pos+8 <= end; pos = b.end {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return b, false
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		b = box{typ: string(header[4:8]), data: pos + 8}
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			if _, err := r.ReadAt(header[8:], pos+8); err != nil {
+				return b, false
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			b.data += 8
+		}
+		if size < b.data-pos || pos+size > end {
+			return b, false
+		}
+		b.end = pos + size
+
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return b, false
+}
+
+// readMP4Capture reads creation_time from the movie header of MP4 and MOV
+// files. The time is stored in UTC.
+func readMP4Capture(r io.ReaderAt, size int64) (c captureInfo, err error) {
+	moov, ok := findBox(r, 0, size, "moov")
+	if !ok {
+		return c, errNoMetadata
+	}
+	mvhd, ok := findBox(r, moov.data, moov.end, "mvhd")
+	if !ok {
+		return c, errNoMetadata
+	}
+
+	var b [12]byte
+	if _, err = r.ReadAt(b[:], mvhd.data); err != nil {
+		return
+	}
+
+	var seconds uint64
+	if b[0] == 1 {
+		seconds = binary.BigEndian.Uint64(b[4:])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(b[4:]))
+	}
+	if seconds == 0 {
+		return c, errNoMetadata
+	}
+
+	c.Time = mp4Epoch.Add(time.Duration(seconds) * time.Second).Local()
+	return
+}
+
+// readCR3Capture reads the CMT1 (IFD0) and CMT2 (Exif IFD) TIFF blocks that
+// Canon stores in a uuid box inside moov.
+func readCR3Capture(r io.ReaderAt, size int64) (c captureInfo, err error) {
+	moov, ok := findBox(r, 0, size, "moov")
+	if !ok {
+		return c, errNoMetadata
+	}
+
+	var id [16]byte
+	for pos := moov.data; ; {
+		uuid, ok := findBox(r, pos, moov.end, "uuid")
+		if !ok {
+			return c, errNoMetadata
+		}
+		pos = uuid.end
+
+		if _, err = r.ReadAt(id[:], uuid.data); err != nil {
+			return
+		}
+		if !bytes.Equal(id[:], canonUUID) {
+			continue
+		}
+
+		if cmt1, ok := findBox(r, uuid.data+16, uuid.end, "CMT1"); ok {
+			c, _ = readTIFFCapture(r, cmt1.data)
+		}
+
+		cmt2, ok := findBox(r, uuid.data+16, uuid.end, "CMT2")
+		if !ok {
+			break
+		}
+		t, offset, err := newTIFFReader(r, cmt2.data)
+		if err != nil {
+			break
+		}
+		exif, err := t.readIFD(offset)
+		if err != nil {
+			break
+		}
+		if value := t.ascii(exif[tagDateTimeOriginal]); value != "" {
+			c.Time, err = parseExifTime(value, t.ascii(exif[tagOffsetTimeOriginal]))
+			return c, err
+		}
+		break
+	}
+
+	if c.Time.IsZero() {
+		return c, errNoMetadata
+	}
+	return
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// makeBox returns a box of type typ holding payload, with a 32-bit size.
+func makeBox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+// makeLargeBox returns a box with a 64-bit size.
+func makeLargeBox(typ string, payload []byte) []byte {
+	b := make([]byte, 16, 16+len(payload))
+	binary.BigEndian.PutUint32(b, 1)
+	copy(b[4:], typ)
+	binary.BigEndian.PutUint64(b[8:], uint64(16+len(payload)))
+	return append(b, payload...)
+}
+
+// makeMvhd returns a movie header created seconds after the MP4 epoch.
+func makeMvhd(version byte, seconds uint64) []byte {
+	if version == 1 {
+		b := make([]byte, 12)
+		b[0] = 1
+		binary.BigEndian.PutUint64(b[4:], seconds)
+		return makeBox("mvhd", b)
+	}
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[4:], uint32(seconds))
+	return makeBox("mvhd", b)
+}
+
+func TestFindBox(t *testing.T) {
+	free := makeBox("free", make([]byte, 4))
+
+	// A size of 0 means the box runs to the end of its parent.
+	toEnd := makeBox("mdat", make([]byte, 10))
+	binary.BigEndian.PutUint32(toEnd, 0)
+
+	truncated := makeBox("moov", make([]byte, 10))
+	truncated = truncated[:12]
+
+	tooSmall := makeBox("free", make([]byte, 8))
+	binary.BigEndian.PutUint32(tooSmall, 4)
+
+	tests := []struct {
+		name string
+		data []byte
+		typ  string
+		ok   bool
+		end  int64
+	}{
+		{name: "after another box", data: append(append([]byte{}, free...), makeBox("moov")...), typ: "moov", ok: true, end: 20},
+		{name: "large size", data: append(append([]byte{}, free...), makeLargeBox("moov", make([]byte, 4))...), typ: "moov", ok: true, end: 32},
+		{name: "zero size runs to the end", data: append(append([]byte{}, free...), toEnd...), typ: "mdat", ok: true, end: 30},
+		{name: "zero size before the box", data: append(append([]byte{}, toEnd...), makeBox("moov")...), typ: "moov"},
+		{name: "missing", data: free, typ: "moov"},
+		{name: "truncated box", data: truncated, typ: "moov"},
+		{name: "size smaller than the header", data: append(tooSmall, makeBox("moov")...), typ: "moov"},
+		{name: "truncated header", data: free[:6], typ: "free"},
+		{name: "empty", typ: "moov"},
+	}
+
+	for _, tt := range tests {
+		b, ok := findBox(bytes.NewReader(tt.data), 0, int64(len(tt.data)), tt.typ)
+		if ok != tt.ok || (ok && b.end != tt.end) {
+			t.Errorf("%s: got %v, end %d, want %v, end %d", tt.name, ok, b.end, tt.ok, tt.end)
+		}
+	}
+}
+
+func TestReadMP4Capture(t *testing.T) {
+	const seconds = 3786912000 // 2024-01-01 00:00:00 UTC
+	want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "version 0", data: makeBox("moov", makeMvhd(0, seconds))},
+		{name: "version 1", data: makeBox("moov", makeMvhd(1, seconds))},
+		{name: "after ftyp", data: append(makeBox("ftyp", []byte("qt  ")), makeBox("moov", makeBox("trak"), makeMvhd(0, seconds))...)},
+		{name: "no creation time", data: makeBox("moov", makeMvhd(0, 0)), wantErr: true},
+		{name: "no mvhd", data: makeBox("moov", makeBox("trak")), wantErr: true},
+		{name: "no moov", data: makeBox("mdat", make([]byte, 16)), wantErr: true},
+		{name: "truncated mvhd", data: makeBox("moov", makeBox("mvhd", make([]byte, 4))), wantErr: true},
+		{name: "truncated file", data: makeBox("moov", makeMvhd(0, seconds))[:20], wantErr: true},
+		{name: "empty", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := readMP4Capture(bytes.NewReader(tt.data), int64(len(tt.data)))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.name)
+			}
+			continue
+		}
+		if err != nil || !c.Time.Equal(want) {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, c.Time, err, want)
+		}
+	}
+}
+
+func TestReadCR3Capture(t *testing.T) {
+	cmt1 := makeTIFF([]tiffTag{{tag: tagMake, ascii: "Canon"}, {tag: tagModel, ascii: "Canon EOS R6"}}, nil)
+	cmt2 := makeTIFF([]tiffTag{{tag: tagDateTimeOriginal, ascii: "2022:03:04 05:06:07"}, {tag: tagOffsetTimeOriginal, ascii: "+01:00"}}, nil)
+	canon := makeBox("uuid", canonUUID, makeBox("CMT1", cmt1), makeBox("CMT2", cmt2))
+	other := makeBox("uuid", make([]byte, 16), makeBox("CMT2", cmt2))
+	want := time.Date(2022, 3, 4, 4, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "Canon uuid", data: makeBox("moov", canon)},
+		{name: "after another uuid", data: makeBox("moov", other, canon)},
+		{name: "only another uuid", data: makeBox("moov", other), wantErr: true},
+		{name: "no CMT2", data: makeBox("moov", makeBox("uuid", canonUUID, makeBox("CMT1", cmt1))), wantErr: true},
+		{name: "truncated CMT2", data: makeBox("moov", makeBox("uuid", canonUUID, makeBox("CMT2", cmt2[:10]))), wantErr: true},
+		{name: "no moov", data: canon, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := readCR3Capture(bytes.NewReader(tt.data), int64(len(tt.data)))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.name)
+			}
+			continue
+		}
+		if err != nil || !c.Time.Equal(want) || c.Camera != "Canon EOS R6" {
+			t.Errorf("%s: got %q %v, %v, want %q %v", tt.name, c.Camera, c.Time, err, "Canon EOS R6", want)
+		}
+	}
+}
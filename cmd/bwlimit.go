@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+var bwLimit string
+
+// bwWindow is a daily time window with its own rate, in bytes per second.
+type bwWindow struct {
+	from time.Duration
+	to   time.Duration
+	rate float64
+}
+
+// contains reports whether the time of day t falls in the window, which may
+// wrap around midnight.
+func (w bwWindow) contains(t time.Duration) bool {
+	if w.from <= w.to {
+		return t >= w.from && t < w.to
+	}
+	return t >= w.from || t < w.to
+}
+
+// limiter is a token bucket shared by every upload, so that --bwlimit caps
+// the total rate however many files are uploaded in parallel.
+type limiter struct {
+	mu       sync.Mutex
+	windows  []bwWindow
+	fallback float64
+	tokens   float64
+	last     time.Time
+}
+
+var bandwidth *limiter
+
+// parseRate parses a rate like 2MB, 2MB/s or 500KiB. 0, off and unlimited
+// mean no limit.
+func parseRate(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	switch strings.ToLower(s) {
+	case "0", "off", "unlimited":
+		return 0, nil
+	}
+	n, err := humanize.ParseBytes(s)
+	return float64(n), err
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q, use HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseBwLimit parses --bwlimit: a comma separated list of rates, each
+// optionally preceded by a time window like 08:00-18:00. A rate without a
+// window applies outside all windows; without one that is unlimited.
+func parseBwLimit(s string) (l *limiter, err error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	l = &limiter{}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		switch len(fields) {
+		case 1:
+			if l.fallback, err = parseRate(fields[0]); err != nil {
+				return nil, fmt.Errorf("bad --bwlimit rate %q: %v", fields[0], err)
+			}
+		case 2:
+			times := strings.Split(fields[0], "-")
+			if len(times) != 2 {
+				return nil, fmt.Errorf("bad --bwlimit window %q, use HH:MM-HH:MM", fields[0])
+			}
+			var w bwWindow
+			if w.from, err = parseClock(times[0]); err != nil {
+				return
+			}
+			if w.to, err = parseClock(times[1]); err != nil {
+				return
+			}
+			if w.rate, err = parseRate(fields[1]); err != nil {
+				return nil, fmt.Errorf("bad --bwlimit rate %q: %v", fields[1], err)
+			}
+			l.windows = append(l.windows, w)
+		default:
+			return nil, fmt.Errorf("bad --bwlimit entry %q", strings.TrimSpace(part))
+		}
+	}
+	return
+}
+
+// rate returns the limit at time now, 0 for none.
+func (l *limiter) rate(now time.Time) float64 {
+	y, m, d := now.Date()
+	clock := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	for _, w := range l.windows {
+		if w.contains(clock) {
+			return w.rate
+		}
+	}
+	return l.fallback
+}
+
+// wait blocks until n more bytes may be sent. The bucket holds at most one
+// second worth of tokens, and goes into debt so that concurrent callers
+// queue up behind each other.
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	rate := l.rate(now)
+	if rate == 0 {
+		l.tokens, l.last = 0, now
+		l.mu.Unlock()
+		return
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// throttledReader reads through the --bwlimit bucket in small pieces, so
+// that the rate stays smooth.
+type throttledReader struct {
+	r io.Reader
+	l *limiter
+}
+
+const throttleChunk = 32 * 1024
+
+func (t *throttledReader) Read(p []byte) (n int, err error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err = t.r.Read(p)
+	if n > 0 {
+		t.l.wait(n)
+	}
+	return
+}
+
+// throttle limits r to --bwlimit.
+func throttle(r io.Reader) io.Reader {
+	if bandwidth == nil {
+		return r
+	}
+	return &throttledReader{r: r, l: bandwidth}
+}
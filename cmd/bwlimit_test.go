@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBwLimit(t *testing.T) {
+	const (
+		kB  = 1000
+		MB  = 1000 * kB
+		MiB = 1 << 20
+	)
+
+	tests := []struct {
+		limit   string
+		rates   map[string]float64 // time of day -> rate at that time
+		wantErr bool
+	}{
+		{limit: "2MB", rates: map[string]float64{"00:00": 2 * MB, "12:00": 2 * MB}},
+		{limit: "500KiB/s", rates: map[string]float64{"12:00": 500 * 1024}},
+		{limit: "off", rates: map[string]float64{"12:00": 0}},
+		{
+			limit: "08:00-18:00 1MiB",
+			rates: map[string]float64{"07:59": 0, "08:00": MiB, "17:59": MiB, "18:00": 0},
+		},
+		{
+			limit: "08:00-18:00 1MB, 10MB",
+			rates: map[string]float64{"03:00": 10 * MB, "12:00": MB, "18:30": 10 * MB},
+		},
+		{
+			// The window wraps past midnight.
+			limit: "22:00-06:00 unlimited, 500kB",
+			rates: map[string]float64{"21:59": 500 * kB, "22:00": 0, "23:59": 0, "00:00": 0, "05:59": 0, "06:00": 500 * kB},
+		},
+		{
+			limit: "09:00-12:00 1MB, 12:00-17:00 2MB",
+			rates: map[string]float64{"08:00": 0, "09:00": MB, "12:00": 2 * MB, "16:59": 2 * MB, "17:00": 0},
+		},
+		{limit: "fast", wantErr: true},
+		{limit: "08:00 1MB", wantErr: true},
+		{limit: "08:00-25:00 1MB", wantErr: true},
+		{limit: "8am-6pm 1MB", wantErr: true},
+		{limit: "08:00-18:00 lots", wantErr: true},
+		{limit: "08:00-18:00 1MB extra", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		l, err := parseBwLimit(tt.limit)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBwLimit(%q): got no error", tt.limit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBwLimit(%q): %v", tt.limit, err)
+			continue
+		}
+
+		for clock, want := range tt.rates {
+			c, err := time.Parse("15:04", clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Date(2024, 3, 1, c.Hour(), c.Minute(), 30, 0, time.Local)
+			if got := l.rate(now); got != want {
+				t.Errorf("parseBwLimit(%q) at %s: rate %v, want %v", tt.limit, clock, got, want)
+			}
+		}
+	}
+}
+
+func TestParseBwLimitEmpty(t *testing.T) {
+	if l, err := parseBwLimit(" "); l != nil || err != nil {
+		t.Errorf("got %v, %v, want no limiter", l, err)
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sources for the client_modified time of uploaded files.
+const (
+	modifiedFromMtime   = "mtime"
+	modifiedFromCapture = "capture"
+)
+
+var (
+	clientModifiedFrom string
+	cameraClockOffset  time.Duration
+)
+
+// captureInfo is what boxit knows about when and with what a photo or
+// video was taken.
+type captureInfo struct {
+	Time   time.Time
+	Camera string
+}
+
+var rawTIFFExtensions = map[string]bool{
+	".cr2": true, ".nef": true, ".nrw": true, ".arw": true, ".srf": true,
+	".sr2": true, ".dng": true, ".tif": true, ".tiff": true, ".orf": true,
+	".rw2": true, ".pef": true,
+}
+
+// readCapture reads the capture time and camera of file from its Exif or
+// QuickTime metadata, falling back to the modification time. The time is
+// corrected by --camera-clock-offset.
+func readCapture(file string) (c captureInfo, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(file))
+	var metaErr error
+	switch {
+	case ext == ".jpg" || ext == ".jpeg":
+		c, metaErr = readJPEGCapture(f)
+	case ext == ".cr3":
+		c, metaErr = readCR3Capture(f, info.Size())
+	case ext == ".mp4" || ext == ".mov" || ext == ".m4v":
+		c, metaErr = readMP4Capture(f, info.Size())
+	case rawTIFFExtensions[ext]:
+		c, metaErr = readTIFFCapture(f, 0)
+	default:
+		metaErr = errNoMetadata
+	}
+
+	if metaErr != nil || c.Time.IsZero() {
+		c.Time = info.ModTime()
+	}
+	c.Time = c.Time.Add(cameraClockOffset)
+	return c, nil
+}
+
+// clientModified returns the time to record as client_modified for src:
+// its modification time or, with --client-modified capture, its capture
+// time.
+func clientModified(src string, info os.FileInfo) (t time.Time, err error) {
+	t = info.ModTime().Add(cameraClockOffset)
+	if clientModifiedFrom == modifiedFromCapture {
+		c, err := readCapture(src)
+		if err != nil {
+			return t, err
+		}
+		t = c.Time
+	}
+	return t.UTC().Round(time.Second), nil
+}
+
+// expandTemplate fills the placeholders of a destination template such as
+// /Photos/{yyyy}/{yyyy-mm-dd}/{camera}/ for one file.
+func expandTemplate(tmpl string, c captureInfo) string {
+	camera := strings.Replace(c.Camera, "/", "-", -1)
+	if camera == "" {
+		camera = "Unknown camera"
+	}
+
+	t := c.Time
+	r := strings.NewReplacer(
+		"{yyyy-mm-dd}", t.Format("2006-01-02"),
+		"{yyyy}", t.Format("2006"),
+		"{yy}", t.Format("06"),
+		"{mm}", t.Format("01"),
+		"{dd}", t.Format("02"),
+		"{camera}", camera,
+	)
+	return path.Clean(r.Replace(tmpl))
+}
+
+func isTemplate(dst string) bool {
+	return strings.Contains(dst, "{")
+}
+
+// templateRoot returns the part of a destination template that does not
+// depend on the file, e.g. /Photos for /Photos/{yyyy}/.
+func templateRoot(tmpl string) string {
+	if !isTemplate(tmpl) {
+		return tmpl
+	}
+	return path.Dir(tmpl[:strings.Index(tmpl, "{")] + "x")
+}
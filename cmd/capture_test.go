@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	c := captureInfo{Time: time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC), Camera: "Canon EOS R5"}
+
+	tests := []struct {
+		tmpl string
+		c    captureInfo
+		want string
+	}{
+		{"/Photos/{yyyy}/{yyyy-mm-dd}", c, "/Photos/2021/2021-06-07"},
+		{"/Photos/{yy}{mm}{dd}/{camera}/", c, "/Photos/210607/Canon EOS R5"},
+		{"/Photos/{camera}", captureInfo{Time: c.Time, Camera: "AC/DC 1"}, "/Photos/AC-DC 1"},
+		{"/Photos/{camera}", captureInfo{Time: c.Time}, "/Photos/Unknown camera"},
+		{"/Photos", c, "/Photos"},
+	}
+
+	for _, tt := range tests {
+		if got := expandTemplate(tt.tmpl, tt.c); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateRoot(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"/Photos/{yyyy}/{yyyy-mm-dd}", "/Photos"},
+		{"/Photos/{yyyy}/", "/Photos"},
+		{"/Photos/{yyyy}-trip", "/Photos"},
+		{"/Photos/Trip {yyyy}", "/Photos"},
+		{"/{camera}", "/"},
+		{"/Photos", "/Photos"},
+	}
+
+	for _, tt := range tests {
+		if got := templateRoot(tt.tmpl); got != tt.want {
+			t.Errorf("templateRoot(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestReadCaptureFallsBackToMtime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boxit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Date(2019, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, name := range []string{"broken.jpg", "broken.mov", "broken.cr3", "broken.nef", "notes.txt"} {
+		file := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(file, []byte("not really"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := readCapture(file)
+		if err != nil || !c.Time.Equal(mtime) || c.Camera != "" {
+			t.Errorf("%s: got %q %v, %v, want the modification time", name, c.Camera, c.Time, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const cardMarkerName = ".boxit-card"
+
+// card is a memory card (or any other volume) files are imported from.
+type card struct {
+	id   string
+	root string
+}
+
+// currentCard is the card the running upload reads from, nil when the
+// source is not on a volume of its own.
+var currentCard *card
+
+var reimport bool
+
+// markerID returns the id in the marker file in root, writing a new one if
+// there is none yet.
+func markerID(root string) string {
+	marker := filepath.Join(root, cardMarkerName)
+	if b, err := ioutil.ReadFile(marker); err == nil {
+		return strings.TrimSpace(string(b))
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	if ioutil.WriteFile(marker, []byte(id+"\n"), 0644) != nil {
+		return ""
+	}
+	return id
+}
+
+// isCardRoot reports whether root looks like a camera card, that is it has
+// a DCIM folder or boxit already marked it.
+func isCardRoot(root string) bool {
+	if info, err := os.Stat(filepath.Join(root, "DCIM")); err == nil && info.IsDir() {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(root, cardMarkerName))
+	return err == nil
+}
+
+// identifyCard fingerprints the card src is on by a marker file in its
+// root, or else by its file system UUID, writing a marker file if it has
+// neither. It returns nil when src is not on a card, and for a card with
+// neither in a dry run, which leaves the card untouched: such a card was
+// never imported anyway.
+func identifyCard(src string) *card {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return nil
+	}
+	root, dev := mountPoint(abs)
+	if root == "" || root == "/" || !isCardRoot(root) {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(root, cardMarkerName)); err == nil {
+		return &card{id: markerID(root), root: root}
+	}
+	if uuid := volumeUUID(dev); uuid != "" {
+		return &card{id: "uuid:" + uuid, root: root}
+	}
+	if dryRun {
+		return nil
+	}
+	if id := markerID(root); id != "" {
+		return &card{id: id, root: root}
+	}
+	return nil
+}
+
+// label is the name the card is mounted under, usually its volume label.
+func (c *card) label() string {
+	return filepath.Base(c.root)
+}
+
+// rel returns the path of file relative to the card root, which stays the
+// same wherever the card is mounted.
+func (c *card) rel(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(c.root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// newOnCard drops the tasks whose source file was already imported from
+// the current card with the same size and modification time.
+func newOnCard(tasks []uploadTask) (remaining []uploadTask, err error) {
+	if currentCard == nil || reimport || uploads == nil {
+		return tasks, nil
+	}
+
+	imported, err := uploads.imported(currentCard.id)
+	if err != nil || len(imported) == 0 {
+		return tasks, err
+	}
+
+	for _, t := range tasks {
+		e, ok := imported[currentCard.rel(t.src)]
+		if ok {
+			info, err := os.Stat(t.src)
+			if err != nil {
+				return nil, err
+			}
+			if info.Size() == e.Size && info.ModTime().Equal(e.ModTime) {
+				continue
+			}
+		}
+		remaining = append(remaining, t)
+	}
+	return
+}
@@ -0,0 +1,13 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package cmd
+
+// mountPoint is not implemented here, so no source is ever on a card.
+func mountPoint(file string) (root string, dev uint64) {
+	return "", 0
+}
+
+func volumeUUID(dev uint64) string {
+	return ""
+}
@@ -0,0 +1,46 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+)
+
+const uuidDir = "/dev/disk/by-uuid"
+
+// mountPoint returns the root of the file system file is on, found by
+// walking up until the device changes.
+func mountPoint(file string) (root string, dev uint64) {
+	dir := file
+	var st syscall.Stat_t
+	if syscall.Stat(dir, &st) != nil {
+		return "", 0
+	}
+
+	for {
+		parent := filepath.Dir(dir)
+		var pst syscall.Stat_t
+		if parent == dir || syscall.Stat(parent, &pst) != nil || pst.Dev != st.Dev {
+			return dir, uint64(st.Dev)
+		}
+		dir = parent
+	}
+}
+
+// volumeUUID looks up the file system UUID of the device dev.
+func volumeUUID(dev uint64) string {
+	links, err := ioutil.ReadDir(uuidDir)
+	if err != nil {
+		return ""
+	}
+	for _, l := range links {
+		var st syscall.Stat_t
+		if syscall.Stat(filepath.Join(uuidDir, l.Name()), &st) == nil && uint64(st.Rdev) == dev {
+			return l.Name()
+		}
+	}
+	return ""
+}
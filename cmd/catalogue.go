@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const catalogueFileName = "catalogue.jsonl"
+
+// catalogueEntry records one file boxit uploaded.
+type catalogueEntry struct {
+	Time        time.Time `json:"time"`
+	Account     string    `json:"account,omitempty"`
+	Source      string    `json:"source"`
+	Card        string    `json:"card,omitempty"`
+	CardLabel   string    `json:"card_label,omitempty"`
+	CardPath    string    `json:"card_path,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentHash string    `json:"content_hash"`
+	Path        string    `json:"path"`
+	ID          string    `json:"id"`
+	Rev         string    `json:"rev"`
+}
+
+// catalogue is the append-only log of everything boxit has uploaded, one
+// JSON object per line, so that a crash can at most lose the last entry.
+type catalogue struct {
+	mu       sync.Mutex
+	filePath string
+}
+
+var uploads *catalogue
+
+func (c *catalogue) add(e catalogueEntry) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(c.filePath), 0700); err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(c.filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(append(b, '\n'))
+	return
+}
+
+// entries reads the whole catalogue. Lines that do not parse, such as one
+// cut short by a crash, are skipped.
+func (c *catalogue) entries() (entries []catalogueEntry, err error) {
+	f, err := os.Open(c.filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e catalogueEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// record adds a successful upload to the catalogue.
+func (c *catalogue) record(src string, file *remoteFile) error {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+
+	e := catalogueEntry{
+		Time:        time.Now().UTC(),
+		Account:     profileName,
+		Source:      abs,
+		Size:        int64(file.Size),
+		ModTime:     info.ModTime(),
+		ContentHash: file.ContentHash,
+		Path:        file.PathDisplay,
+		ID:          file.Id,
+		Rev:         file.Rev,
+	}
+	if currentCard != nil {
+		if e.CardPath = currentCard.rel(abs); e.CardPath != "" {
+			e.Card = currentCard.id
+			e.CardLabel = currentCard.label()
+		}
+	}
+	return c.add(e)
+}
+
+// imported returns the files imported from the card with the given id into
+// the current account, keyed by their path on the card. Later imports
+// replace earlier ones.
+func (c *catalogue) imported(id string) (files map[string]catalogueEntry, err error) {
+	entries, err := c.entries()
+	if err != nil {
+		return
+	}
+
+	files = make(map[string]catalogueEntry)
+	for _, e := range entries {
+		account := e.Account
+		if account == "" {
+			account = tokenPersonal
+		}
+		if e.Card == id && e.CardPath != "" && account == profileName {
+			files[e.CardPath] = e
+		}
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	deleteAfterUpload bool
+	keepDays          int
+)
+
+// cleanup removes local files once their content is confirmed to be in
+// Dropbox, for --delete-after-upload.
+type cleanup struct {
+	root    string
+	removed []string
+	bytes   int64
+	kept    int
+}
+
+var sweeper *cleanup
+
+// remove deletes src, which uploadAll verified against the content hash of
+// file, unless it is newer than --keep-days.
+func (c *cleanup) remove(src string, file *remoteFile) (err error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return
+	}
+
+	if time.Since(info.ModTime()) < time.Duration(keepDays)*24*time.Hour {
+		c.kept++
+		return
+	}
+
+	if uint64(info.Size()) != file.Size {
+		return fmt.Errorf("%s changed since it was uploaded", src)
+	}
+
+	if err = os.Remove(src); err != nil {
+		return
+	}
+	c.removed = append(c.removed, src)
+	c.bytes += info.Size()
+	logf(os.Stdout, "removed %s\n", src)
+	return
+}
+
+// pruneDirs removes the directories of the files deleted by remove that
+// are left empty, deepest first, up to but not including root.
+func (c *cleanup) pruneDirs() {
+	if c.root == "" || len(c.removed) == 0 {
+		return
+	}
+
+	root := filepath.Clean(c.root)
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, file := range c.removed {
+		for dir := filepath.Dir(filepath.Clean(file)); dir != root && !seen[dir]; dir = filepath.Dir(dir) {
+			if rel, err := filepath.Rel(root, dir); err != nil || strings.HasPrefix(rel, "..") {
+				break
+			}
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	for _, dir := range dirs {
+		// os.Remove refuses to delete directories that are not empty.
+		os.Remove(dir)
+	}
+}
+
+func (c *cleanup) summary() {
+	fmt.Printf("removed %d local files (%s)", len(c.removed), humanize.IBytes(uint64(c.bytes)))
+	if c.kept > 0 {
+		fmt.Printf(", kept %d newer than %d days", c.kept, keepDays)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+// What to do when a file with different content already exists at the
+// destination. Files with identical content are always skipped.
+const (
+	conflictSkip      = "skip"
+	conflictRename    = "rename"
+	conflictOverwrite = "overwrite"
+	conflictFail      = "fail"
+)
+
+var (
+	onConflict    string
+	matchAnywhere bool
+)
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case conflictSkip, conflictRename, conflictOverwrite, conflictFail:
+		return true
+	}
+	return false
+}
+
+// remoteIndex holds what is already in the folders an upload writes to, so
+// that directory uploads need a listing per folder instead of a metadata call
+// per file. With matchMoved, a file whose content exists anywhere in the
+// index counts as uploaded, even under another name.
+type remoteIndex struct {
+	byPath     map[string]*remoteFile
+	bySize     map[uint64][]*remoteFile
+	matchMoved bool
+}
+
+var remote *remoteIndex
+
+func newRemoteIndex(entries []*remoteFile) *remoteIndex {
+	x := &remoteIndex{
+		byPath: make(map[string]*remoteFile),
+		bySize: make(map[uint64][]*remoteFile),
+	}
+	for _, e := range entries {
+		x.byPath[e.PathLower] = e
+		if e.Tag == "file" {
+			x.bySize[e.Size] = append(x.bySize[e.Size], e)
+		}
+	}
+	return x
+}
+
+func (x *remoteIndex) file(dst string) *remoteFile {
+	return x.byPath[strings.ToLower(dst)]
+}
+
+func (x *remoteIndex) withContent(size uint64, hash string) *remoteFile {
+	for _, e := range x.bySize[size] {
+		if e.ContentHash == hash {
+			return e
+		}
+	}
+	return nil
+}
+
+// What uploading a file does, as decided by planFile.
+const (
+	actionUpload    = "upload"
+	actionIdentical = "skip-identical"
+	actionExists    = "skip-existing"
+	actionRename    = "rename"
+	actionOverwrite = "overwrite"
+	actionFail      = "fail"
+)
+
+// planFile decides what uploading src to dst does given what is already in
+// Dropbox, using read-only calls. same is the remote file with the same
+// content as src, if there is one.
+func planFile(src string, info os.FileInfo, dst string) (action string, same *remoteFile, err error) {
+	size := uint64(info.Size())
+
+	var existing *remoteFile
+	if remote != nil {
+		existing = remote.file(dst)
+	} else if existing, err = getRemoteFile(dst); err != nil {
+		return
+	}
+
+	// A folder at dst has no content to compare with. It is in the way like a
+	// different file, so --on-conflict decides.
+	isFile := existing != nil && existing.Tag == "file"
+
+	var hash string
+	matchMoved := remote != nil && remote.matchMoved
+	if (isFile && existing.Size == size) || (matchMoved && len(remote.bySize[size]) > 0) {
+		if hash, err = fileContentHash(src); err != nil {
+			return
+		}
+	}
+
+	if isFile && hash != "" && existing.ContentHash == hash {
+		return actionIdentical, existing, nil
+	}
+	if matchMoved && hash != "" {
+		if same = remote.withContent(size, hash); same != nil {
+			return actionIdentical, same, nil
+		}
+	}
+
+	if existing == nil {
+		return actionUpload, nil, nil
+	}
+
+	switch onConflict {
+	case conflictSkip:
+		return actionExists, nil, nil
+	case conflictRename:
+		return actionRename, nil, nil
+	case conflictOverwrite:
+		return actionOverwrite, nil, nil
+	}
+	return actionFail, nil, nil
+}
+
+// resolveConflict compares src with what is already in Dropbox and either
+// reports that the upload can be skipped or sets the write mode of
+// commitInfo according to onConflict. same is the remote file with the
+// same content as src, if there is one.
+func resolveConflict(src string, info os.FileInfo, commitInfo *files.CommitInfo) (same *remoteFile, skip bool, err error) {
+	dst := commitInfo.Path
+
+	action, same, err := planFile(src, info, dst)
+	if err != nil {
+		return
+	}
+
+	switch action {
+	case actionIdentical:
+		if same.PathLower == strings.ToLower(dst) {
+			logf(os.Stdout, "%s is already uploaded\n", path.Base(src))
+		} else {
+			logf(os.Stdout, "%s is already uploaded as %s\n", path.Base(src), same.PathDisplay)
+		}
+		return same, true, nil
+	case actionExists:
+		logf(os.Stdout, "%s exists!\n", path.Base(src))
+		return nil, true, nil
+	case actionRename:
+		commitInfo.Autorename = true
+	case actionOverwrite:
+		commitInfo.Mode.Tag = files.WriteModeOverwrite
+	case actionFail:
+		return nil, false, fmt.Errorf("%s already exists with different content", dst)
+	}
+	return
+}
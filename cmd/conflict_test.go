@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boxit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "IMG_0001.JPG")
+	if err = ioutil.WriteFile(src, []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fileContentHash(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const dst = "/Photos/IMG_0001.JPG"
+	same := &remoteFile{Tag: "file", PathLower: "/photos/img_0001.jpg", Size: 5, ContentHash: hash}
+	different := &remoteFile{Tag: "file", PathLower: "/photos/img_0001.jpg", Size: 5, ContentHash: "0000"}
+	moved := &remoteFile{Tag: "file", PathLower: "/photos/old/img_0001.jpg", Size: 5, ContentHash: hash}
+	folder := &remoteFile{Tag: "folder", PathLower: "/photos/img_0001.jpg"}
+
+	tests := []struct {
+		name       string
+		entries    []*remoteFile
+		matchMoved bool
+		policy     string
+		want       string
+	}{
+		{name: "nothing there", want: actionUpload},
+		{name: "same content", entries: []*remoteFile{same}, want: actionIdentical},
+		{name: "different content", entries: []*remoteFile{different}, policy: conflictRename, want: actionRename},
+		{name: "different content, skip", entries: []*remoteFile{different}, policy: conflictSkip, want: actionExists},
+		{name: "moved", entries: []*remoteFile{moved}, matchMoved: true, want: actionIdentical},
+		{name: "moved, not matched", entries: []*remoteFile{moved}, want: actionUpload},
+		{name: "folder in the way", entries: []*remoteFile{folder}, policy: conflictRename, want: actionRename},
+		{name: "folder in the way, fail", entries: []*remoteFile{folder}, policy: conflictFail, want: actionFail},
+		{name: "folder in the way, moved", entries: []*remoteFile{folder}, matchMoved: true, policy: conflictSkip, want: actionExists},
+	}
+
+	defer func(policy string) {
+		onConflict = policy
+		remote = nil
+	}(onConflict)
+	for _, tt := range tests {
+		onConflict = tt.policy
+		remote = newRemoteIndex(tt.entries)
+		remote.matchMoved = tt.matchMoved
+
+		got, _, err := planFile(src, info, dst)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %s, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Environment variables that supply credentials without a token file.
+const (
+	envToken        = "BOXIT_TOKEN"
+	envRefreshToken = "BOXIT_REFRESH_TOKEN"
+	envAppKey       = "BOXIT_APP_KEY"
+)
+
+var (
+	tokenFile      string
+	tokenFD        int
+	nonInteractive bool
+
+	// tokenOrigin says where the token in use came from when it is not the
+	// token file, which boxit then leaves alone.
+	tokenOrigin string
+)
+
+// clientID is the app key, which BOXIT_APP_KEY overrides for those who
+// registered their own Dropbox app.
+func clientID() string {
+	if key := os.Getenv(envAppKey); key != "" {
+		return key
+	}
+	return appKey
+}
+
+// interactive reports whether boxit may ask the user something.
+func interactive() bool {
+	return !nonInteractive && isTerminal(os.Stdin)
+}
+
+// errNonInteractive is returned instead of prompting.
+var errNonInteractive = errors.New("not asking for input: --non-interactive is set or stdin is not a terminal")
+
+// parseToken reads credentials from a token file or descriptor: a JSON
+// token as stored in auth.json, or just an access token.
+func parseToken(b []byte) (*oauth2.Token, error) {
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return nil, errors.New("no token")
+	}
+	if !strings.HasPrefix(s, "{") {
+		return &oauth2.Token{AccessToken: s}, nil
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(s), &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" && token.RefreshToken == "" {
+		return nil, errors.New("no access_token or refresh_token")
+	}
+	return &token, nil
+}
+
+// externalToken returns credentials given outside the token file. In order
+// of precedence they come from --token-fd, --token-file, or BOXIT_TOKEN and
+// BOXIT_REFRESH_TOKEN. token is nil when there are none.
+func externalToken() (token *oauth2.Token, origin string, err error) {
+	switch {
+	case tokenFD >= 0:
+		origin = fmt.Sprintf("file descriptor %d", tokenFD)
+		f := os.NewFile(uintptr(tokenFD), origin)
+		if f == nil {
+			return nil, origin, fmt.Errorf("bad --token-fd %d", tokenFD)
+		}
+		defer f.Close()
+
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, origin, err
+		}
+		token, err = parseToken(b)
+		return token, origin, err
+
+	case tokenFile != "":
+		origin = tokenFile
+		b, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, origin, err
+		}
+		token, err = parseToken(b)
+		return token, origin, err
+
+	case os.Getenv(envToken) != "" || os.Getenv(envRefreshToken) != "":
+		origin = "the environment"
+		token = &oauth2.Token{
+			AccessToken:  os.Getenv(envToken),
+			RefreshToken: os.Getenv(envRefreshToken),
+		}
+	}
+	return
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&tokenFile, "token-file", "", "read the token from this file instead of auth.json")
+	RootCmd.PersistentFlags().IntVar(&tokenFD, "token-fd", -1, "read the token from this open file descriptor")
+	RootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "fail instead of asking for input")
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestParseToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    *oauth2.Token
+		wantErr bool
+	}{
+		{"raw", "sl.abc\n", &oauth2.Token{AccessToken: "sl.abc"}, false},
+		{"json", `{"access_token": "sl.abc", "token_type": "bearer"}`, &oauth2.Token{AccessToken: "sl.abc", TokenType: "bearer"}, false},
+		{"json with refresh token only", ` {"refresh_token": "r"} `, &oauth2.Token{RefreshToken: "r"}, false},
+		{"empty", " \n", nil, true},
+		{"bad json", `{"access_token": `, nil, true},
+		{"json without a token", `{"token_type": "bearer"}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseToken([]byte(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExternalToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boxit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+	if err = ioutil.WriteFile(file, []byte("from-file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(file string, fd int, token, refresh string) {
+		tokenFile, tokenFD = file, fd
+		os.Setenv(envToken, token)
+		os.Setenv(envRefreshToken, refresh)
+	}(tokenFile, tokenFD, os.Getenv(envToken), os.Getenv(envRefreshToken))
+
+	tests := []struct {
+		name       string
+		fd         string // written to a pipe given as --token-fd
+		file       string
+		env        string
+		envRefresh string
+		wantToken  *oauth2.Token
+		wantOrigin string
+	}{
+		{
+			name: "fd first", fd: "from-fd", file: file, env: "from-env",
+			wantToken: &oauth2.Token{AccessToken: "from-fd"}, wantOrigin: "file descriptor",
+		},
+		{
+			name: "then the file", file: file, env: "from-env",
+			wantToken: &oauth2.Token{AccessToken: "from-file"}, wantOrigin: file,
+		},
+		{
+			name: "then the environment", env: "from-env", envRefresh: "refresh",
+			wantToken: &oauth2.Token{AccessToken: "from-env", RefreshToken: "refresh"}, wantOrigin: "the environment",
+		},
+		{
+			name: "refresh token in the environment", envRefresh: "refresh",
+			wantToken: &oauth2.Token{RefreshToken: "refresh"}, wantOrigin: "the environment",
+		},
+		{name: "none, so auth.json is used"},
+	}
+
+	for _, tt := range tests {
+		tokenFile, tokenFD = tt.file, -1
+		os.Setenv(envToken, tt.env)
+		os.Setenv(envRefreshToken, tt.envRefresh)
+
+		var r *os.File
+		if tt.fd != "" {
+			var w *os.File
+			if r, w, err = os.Pipe(); err != nil {
+				t.Fatal(err)
+			}
+			w.WriteString(tt.fd)
+			w.Close()
+			tokenFD = int(r.Fd())
+		}
+
+		token, origin, err := externalToken()
+		if r != nil {
+			// externalToken closed the descriptor already.
+			r.Close()
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(token, tt.wantToken) || !strings.HasPrefix(origin, tt.wantOrigin) {
+			t.Errorf("%s: got %+v from %q, want %+v from %q", tt.name, token, origin, tt.wantToken, tt.wantOrigin)
+		}
+	}
+}
+
+func TestExternalTokenMissingFile(t *testing.T) {
+	defer func(file string, fd int) { tokenFile, tokenFD = file, fd }(tokenFile, tokenFD)
+	tokenFile, tokenFD = filepath.Join(os.TempDir(), "boxit-no-such-token"), -1
+
+	if _, origin, err := externalToken(); err == nil || origin != tokenFile {
+		t.Errorf("got %q, %v, want an error from %s", origin, err, tokenFile)
+	}
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// downloadTask pairs a Dropbox file with the local path it is downloaded to.
+type downloadTask struct {
+	file *remoteFile
+	dst  string
+}
+
+type downloadResult struct {
+	task    downloadTask
+	skipped bool
+	err     error
+}
+
+// pathDepth returns the number of components of a Dropbox path.
+func pathDepth(p string) int {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return 0
+	}
+	return strings.Count(p, "/") + 1
+}
+
+// localPath maps a Dropbox file below a root of the given depth to its path
+// below dst, keeping the relative directory structure.
+func localPath(dst string, depth int, file *remoteFile) string {
+	parts := strings.Split(strings.Trim(file.PathDisplay, "/"), "/")
+	return filepath.Join(dst, filepath.Join(parts[depth:]...))
+}
+
+// partialPath is where a download is written until its hash is verified.
+// The rev is part of the name so that a partial download is only resumed
+// for the same revision.
+func partialPath(dst string, rev string) string {
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+"."+rev+".part")
+}
+
+// sameLocalFile reports whether local already holds the content of file.
+func sameLocalFile(local string, file *remoteFile) bool {
+	info, err := os.Stat(local)
+	if err != nil || info.Size() != int64(file.Size) {
+		return false
+	}
+	hash, err := fileContentHash(local)
+	return err == nil && hash == file.ContentHash
+}
+
+// fetch appends the missing part of file to part, asking the server for
+// the range after what part already holds.
+func fetch(file *remoteFile, part string, t *transfer) (err error) {
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	if uint64(offset) >= file.Size {
+		offset = 0
+	}
+
+	body, partial, err := download("rev:"+file.Rev, offset)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+
+	if !partial {
+		offset = 0
+	}
+	if err = f.Truncate(offset); err != nil {
+		return
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	t.set(offset)
+	_, err = io.Copy(f, t.reader(body))
+	return
+}
+
+// downloadFile downloads one file, resuming a partial download of the same
+// revision. skipped is set when the local file already has the content.
+func downloadFile(t downloadTask) (skipped bool, err error) {
+	if sameLocalFile(t.dst, t.file) {
+		return true, nil
+	}
+
+	if err = os.MkdirAll(filepath.Dir(t.dst), 0755); err != nil {
+		return
+	}
+
+	part := partialPath(t.dst, t.file.Rev)
+	progress := display.begin(t.file.Name, int64(t.file.Size), 0)
+	defer progress.end()
+	err = retry("download "+t.file.Name, func() error {
+		return fetch(t.file, part, progress)
+	})
+	if err != nil {
+		return
+	}
+
+	hash, err := fileContentHash(part)
+	if err != nil {
+		return
+	}
+	if hash != t.file.ContentHash {
+		os.Remove(part)
+		return false, fmt.Errorf("content hash mismatch for %s", t.file.PathDisplay)
+	}
+
+	if err = os.Chtimes(part, t.file.ClientModified, t.file.ClientModified); err != nil {
+		return
+	}
+	return false, os.Rename(part, t.dst)
+}
+
+// downloadAll downloads tasks with a pool of `jobs` workers and reports the
+// outcome of every file.
+func downloadAll(tasks []downloadTask) (done []downloadResult, err error) {
+	workers := jobs
+	if workers < 1 {
+		workers = 1
+	}
+
+	var size int64
+	for _, t := range tasks {
+		size += int64(t.file.Size)
+	}
+	p := startProgress("downloaded", len(tasks), size)
+
+	queue := make(chan downloadTask)
+	results := make(chan downloadResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				skipped, err := downloadFile(t)
+				display.fileDone(int64(t.file.Size))
+				results <- downloadResult{task: t, skipped: skipped, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, t := range tasks {
+			queue <- t
+		}
+		close(queue)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	downloaded, skipped, failed := 0, 0, 0
+	for r := range results {
+		done = append(done, r)
+		switch {
+		case r.err != nil:
+			logf(os.Stderr, "failed     %s: %v\n", r.task.file.PathDisplay, r.err)
+			failed++
+		case r.skipped:
+			skipped++
+		default:
+			logf(os.Stdout, "downloaded %s -> %s\n", r.task.file.PathDisplay, r.task.dst)
+			downloaded++
+		}
+	}
+
+	p.finish()
+	fmt.Printf("%d downloaded, %d skipped, %d failed\n", downloaded, skipped, failed)
+	if failed > 0 {
+		return done, fmt.Errorf("%d files failed to download", failed)
+	}
+	return done, nil
+}
+
+func downloadRun(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 2 {
+		return errors.New("`download` requires `dropbox-path` and `local-dir` arguments")
+	}
+	src, dst := path.Clean("/"+args[0]), args[1]
+
+	var entries []*remoteFile
+	depth := pathDepth(src)
+	if depth > 0 {
+		file, err := getRemoteFile(src)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return fmt.Errorf("%s not found in Dropbox", src)
+		}
+		if file.Tag == "file" {
+			entries = []*remoteFile{file}
+			depth = pathDepth(path.Dir(file.PathDisplay))
+		}
+	}
+
+	if entries == nil {
+		if entries, err = listRemoteFiles(src); err != nil {
+			return
+		}
+	}
+
+	var tasks []downloadTask
+	for _, e := range entries {
+		tasks = append(tasks, downloadTask{file: e, dst: localPath(dst, depth, e)})
+	}
+	_, err = downloadAll(tasks)
+	return
+}
+
+var downloadCmd = &cobra.Command{
+	Use:   "download <dropbox-path> <local-dir>",
+	Short: "Download files",
+	RunE:  downloadRun,
+}
+
+func init() {
+	downloadCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "number of files to download in parallel")
+	RootCmd.AddCommand(downloadCmd)
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// TIFF tags boxit reads from IFD0 and the Exif IFD.
+const (
+	tagMake               = 0x010f
+	tagModel              = 0x0110
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+
+	tiffTypeASCII = 2
+	tiffTypeLong  = 4
+
+	exifTimeLayout = "2006:01:02 15:04:05"
+	maxIFDEntries  = 1024
+)
+
+var errNoMetadata = errors.New("no capture metadata")
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value [4]byte
+}
+
+// tiffReader reads IFDs from a TIFF structure starting at base in r. Offsets
+// inside the structure are relative to base.
+type tiffReader struct {
+	r     io.ReaderAt
+	base  int64
+	order binary.ByteOrder
+}
+
+func newTIFFReader(r io.ReaderAt, base int64) (*tiffReader, uint32, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], base); err != nil {
+		return nil, 0, err
+	}
+
+	t := &tiffReader{r: r, base: base}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, 0, errNoMetadata
+	}
+	return t, t.order.Uint32(header[4:]), nil
+}
+
+func (t *tiffReader) readIFD(offset uint32) (map[uint16]ifdEntry, error) {
+	var n [2]byte
+	if _, err := t.r.ReadAt(n[:], t.base+int64(offset)); err != nil {
+		return nil, err
+	}
+
+	count := int(t.order.Uint16(n[:]))
+	if count > maxIFDEntries {
+		return nil, errNoMetadata
+	}
+
+	b := make([]byte, count*12)
+	if _, err := t.r.ReadAt(b, t.base+int64(offset)+2); err != nil {
+		return nil, err
+	}
+
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		e := b[i*12 : (i+1)*12]
+		var entry ifdEntry
+		entry.typ = t.order.Uint16(e[2:])
+		entry.count = t.order.Uint32(e[4:])
+		copy(entry.value[:], e[8:])
+		entries[t.order.Uint16(e)] = entry
+	}
+	return entries, nil
+}
+
+func (t *tiffReader) ascii(e ifdEntry) string {
+	if e.typ != tiffTypeASCII || e.count == 0 || e.count > 1024 {
+		return ""
+	}
+
+	b := e.value[:]
+	if e.count > 4 {
+		b = make([]byte, e.count)
+		if _, err := t.r.ReadAt(b, t.base+int64(t.order.Uint32(e.value[:]))); err != nil {
+			return ""
+		}
+	} else {
+		b = b[:e.count]
+	}
+	return strings.TrimSpace(string(bytes.TrimRight(b, "\x00")))
+}
+
+func (t *tiffReader) long(e ifdEntry) uint32 {
+	if e.typ != tiffTypeLong {
+		return 0
+	}
+	return t.order.Uint32(e.value[:])
+}
+
+// parseExifTime parses an Exif date. Cameras record local time without a
+// zone, so the local zone is assumed unless an OffsetTime tag is present.
+func parseExifTime(value string, offset string) (time.Time, error) {
+	if offset != "" {
+		if t, err := time.Parse(exifTimeLayout+"-07:00", value+offset); err == nil {
+			return t, nil
+		}
+	}
+	return time.ParseInLocation(exifTimeLayout, value, time.Local)
+}
+
+// readTIFFCapture reads the camera and capture time from the TIFF structure
+// at base, as found in Exif blocks and TIFF based raw files.
+func readTIFFCapture(r io.ReaderAt, base int64) (c captureInfo, err error) {
+	t, offset, err := newTIFFReader(r, base)
+	if err != nil {
+		return
+	}
+
+	ifd0, err := t.readIFD(offset)
+	if err != nil {
+		return
+	}
+	c.Camera = cameraName(t.ascii(ifd0[tagMake]), t.ascii(ifd0[tagModel]))
+
+	value := t.ascii(ifd0[tagDateTime])
+	var zone string
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if exif, err := t.readIFD(t.long(e)); err == nil {
+			if original := t.ascii(exif[tagDateTimeOriginal]); original != "" {
+				value = original
+			}
+			zone = t.ascii(exif[tagOffsetTimeOriginal])
+		}
+	}
+
+	if value == "" {
+		return c, errNoMetadata
+	}
+	c.Time, err = parseExifTime(value, zone)
+	return
+}
+
+// readJPEGCapture finds the Exif APP1 segment of a JPEG file.
+func readJPEGCapture(r io.ReaderAt) (c captureInfo, err error) {
+	var marker [4]byte
+	if _, err = r.ReadAt(marker[:2], 0); err != nil {
+		return
+	}
+	if marker[0] != 0xff || marker[1] != 0xd8 {
+		return c, errNoMetadata
+	}
+
+	for offset := int64(2); ; {
+		if _, err = r.ReadAt(marker[:], offset); err != nil {
+			return
+		}
+		if marker[0] != 0xff || marker[1] == 0xda {
+			return c, errNoMetadata
+		}
+
+		length := int64(binary.BigEndian.Uint16(marker[2:]))
+		if marker[1] == 0xe1 {
+			var id [6]byte
+			if _, err = r.ReadAt(id[:], offset+4); err != nil {
+				return
+			}
+			if string(id[:]) == "Exif\x00\x00" {
+				return readTIFFCapture(r, offset+10)
+			}
+		}
+		offset += 2 + length
+	}
+}
+
+// cameraName combines the Make and Model tags, which some vendors repeat
+// and others do not: "Canon EOS R5" but "SONY" and "ILCE-7M3".
+func cameraName(maker, model string) string {
+	if model == "" {
+		return maker
+	}
+	fields := strings.Fields(maker)
+	if len(fields) > 0 && !strings.HasPrefix(strings.ToLower(model), strings.ToLower(fields[0])) {
+		return fields[0] + " " + model
+	}
+	return model
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// tiffTag is an IFD entry for makeTIFF: ASCII when ascii is set, else LONG.
+type tiffTag struct {
+	tag   uint16
+	ascii string
+	long  uint32
+}
+
+// makeTIFF lays out a little-endian TIFF structure with ifd0 and, if exif is
+// not nil, an Exif IFD linked from ifd0.
+func makeTIFF(ifd0 []tiffTag, exif []tiffTag) []byte {
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+	n0 := len(ifd0)
+	if exif != nil {
+		n0++
+	}
+	exifOffset := 8 + ifdSize(n0)
+	dataOffset := exifOffset
+	if exif != nil {
+		dataOffset += ifdSize(len(exif))
+	}
+
+	order := binary.LittleEndian
+	var data []byte
+	writeIFD := func(b *bytes.Buffer, tags []tiffTag) {
+		binary.Write(b, order, uint16(len(tags)))
+		for _, tag := range tags {
+			var e [12]byte
+			order.PutUint16(e[:], tag.tag)
+			if tag.ascii != "" {
+				s := append([]byte(tag.ascii), 0)
+				order.PutUint16(e[2:], tiffTypeASCII)
+				order.PutUint32(e[4:], uint32(len(s)))
+				if len(s) <= 4 {
+					copy(e[8:], s)
+				} else {
+					order.PutUint32(e[8:], uint32(dataOffset+len(data)))
+					data = append(data, s...)
+				}
+			} else {
+				order.PutUint16(e[2:], tiffTypeLong)
+				order.PutUint32(e[4:], 1)
+				order.PutUint32(e[8:], tag.long)
+			}
+			b.Write(e[:])
+		}
+		b.Write([]byte{0, 0, 0, 0})
+	}
+
+	var b bytes.Buffer
+	b.WriteString("II*\x00")
+	binary.Write(&b, order, uint32(8))
+	if exif != nil {
+		ifd0 = append(ifd0, tiffTag{tag: tagExifIFD, long: uint32(exifOffset)})
+	}
+	writeIFD(&b, ifd0)
+	if exif != nil {
+		writeIFD(&b, exif)
+	}
+	b.Write(data)
+	return b.Bytes()
+}
+
+// makeJPEG wraps tiff in the Exif APP1 segment of an otherwise empty JPEG.
+func makeJPEG(tiff []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, 0xd8})
+	// An APP0 segment first, as most cameras write.
+	b.Write([]byte{0xff, 0xe0, 0, 4, 0, 0})
+	b.Write([]byte{0xff, 0xe1})
+	binary.Write(&b, binary.BigEndian, uint16(2+6+len(tiff)))
+	b.WriteString("Exif\x00\x00")
+	b.Write(tiff)
+	b.Write([]byte{0xff, 0xda, 0, 2})
+	return b.Bytes()
+}
+
+func TestReadTIFFCapture(t *testing.T) {
+	camera := []tiffTag{{tag: tagMake, ascii: "Canon"}, {tag: tagModel, ascii: "Canon EOS R5"}}
+	// An Exif IFD far beyond the end of the data.
+	badExif := makeTIFF(append(camera, tiffTag{tag: tagDateTime, ascii: "2020:01:02 03:04:05"}, tiffTag{tag: tagExifIFD, long: 1 << 20}), nil)
+
+	badIFD0 := makeTIFF(camera, nil)
+	binary.LittleEndian.PutUint32(badIFD0[4:], 1<<20)
+
+	tooMany := makeTIFF(camera, nil)
+	binary.LittleEndian.PutUint16(tooMany[8:], maxIFDEntries+1)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		camera  string
+		time    time.Time
+		wantErr bool
+	}{
+		{
+			name:   "DateTimeOriginal with offset",
+			data:   makeTIFF(append(camera, tiffTag{tag: tagDateTime, ascii: "2020:01:02 03:04:05"}), []tiffTag{{tag: tagDateTimeOriginal, ascii: "2021:06:07 08:09:10"}, {tag: tagOffsetTimeOriginal, ascii: "+02:00"}}),
+			camera: "Canon EOS R5",
+			time:   time.Date(2021, 6, 7, 6, 9, 10, 0, time.UTC),
+		},
+		{
+			name:   "DateTime only",
+			data:   makeTIFF([]tiffTag{{tag: tagMake, ascii: "SONY"}, {tag: tagModel, ascii: "ILCE-7M3"}, {tag: tagDateTime, ascii: "2020:01:02 03:04:05"}}, nil),
+			camera: "SONY ILCE-7M3",
+			time:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local),
+		},
+		{
+			name:   "bad Exif IFD offset falls back to DateTime",
+			data:   badExif,
+			camera: "Canon EOS R5",
+			time:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local),
+		},
+		{name: "bad IFD0 offset", data: badIFD0, wantErr: true},
+		{name: "too many entries", data: tooMany, wantErr: true},
+		{name: "no time", data: makeTIFF(camera, nil), camera: "Canon EOS R5", wantErr: true},
+		{name: "bad byte order", data: []byte("XX*\x00\x08\x00\x00\x00"), wantErr: true},
+		{name: "truncated header", data: []byte("II*\x00"), wantErr: true},
+		{name: "empty", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := readTIFFCapture(bytes.NewReader(tt.data), 0)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if c.Camera != tt.camera || !c.Time.Equal(tt.time) {
+			t.Errorf("%s: got %q %v, want %q %v", tt.name, c.Camera, c.Time, tt.camera, tt.time)
+		}
+	}
+}
+
+func TestReadJPEGCapture(t *testing.T) {
+	tiff := makeTIFF([]tiffTag{{tag: tagDateTime, ascii: "2020:01:02 03:04:05"}}, nil)
+	jpeg := makeJPEG(tiff)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "Exif", data: jpeg},
+		{name: "no Exif", data: []byte{0xff, 0xd8, 0xff, 0xda, 0, 2}, wantErr: true},
+		{name: "not a JPEG", data: []byte("\x89PNG\r\n\x1a\n"), wantErr: true},
+		{name: "truncated segment", data: jpeg[:12], wantErr: true},
+		{name: "truncated Exif", data: jpeg[:len(jpeg)-len(tiff)/2], wantErr: true},
+		{name: "empty", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := readJPEGCapture(bytes.NewReader(tt.data))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.name)
+			}
+			continue
+		}
+		if want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local); err != nil || !c.Time.Equal(want) {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, c.Time, err, want)
+		}
+	}
+}
+
+func TestCameraName(t *testing.T) {
+	tests := []struct {
+		maker, model, want string
+	}{
+		{"Canon", "Canon EOS R5", "Canon EOS R5"},
+		{"SONY", "ILCE-7M3", "SONY ILCE-7M3"},
+		{"NIKON CORPORATION", "NIKON Z 6", "NIKON Z 6"},
+		{"FUJIFILM", "", "FUJIFILM"},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := cameraName(tt.maker, tt.model); got != tt.want {
+			t.Errorf("cameraName(%q, %q) = %q, want %q", tt.maker, tt.model, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+const ignoreFileName = ".boxitignore"
+
+var (
+	includeGlobs []string
+	excludeGlobs []string
+	onlyKinds    []string
+	minSize      string
+	maxSize      string
+	since        string
+	until        string
+)
+
+// Extensions selected by the kinds --only accepts. Anything else given to
+// --only is taken as an extension.
+var fileKinds = map[string][]string{
+	"raw":   {".cr2", ".cr3", ".crw", ".nef", ".nrw", ".arw", ".srf", ".sr2", ".dng", ".orf", ".rw2", ".pef", ".raf", ".srw", ".x3f", ".3fr", ".iiq"},
+	"jpeg":  {".jpg", ".jpeg"},
+	"heif":  {".heic", ".heif", ".hif"},
+	"video": {".mp4", ".mov", ".avi", ".mts", ".m2ts", ".mxf", ".crm", ".3gp"},
+}
+
+// Files no user ever wants uploaded, left behind on removable media by
+// operating systems and by cameras: thumbnails (THMBNL, .THM) and the
+// catalogue files of Canon cameras (.CTG).
+var systemJunk = []string{
+	".Trashes", ".Spotlight-V100", ".fseventsd", ".TemporaryItems", ".DS_Store",
+	"._*", "Thumbs.db", "desktop.ini", "System Volume Information", "$RECYCLE.BIN",
+	"THMBNL", "*.THM", "*.thm", "*.CTG", "*.ctg",
+}
+
+// fileFilter is the selection made by the filter flags.
+type fileFilter struct {
+	include []string
+	exclude []string
+	exts    map[string]bool
+	minSize uint64
+	maxSize uint64
+	since   time.Time
+	until   time.Time
+}
+
+var filters *fileFilter
+
+func parseDate(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// newFileFilter checks and parses the filter flags.
+func newFileFilter() (f *fileFilter, err error) {
+	f = &fileFilter{include: includeGlobs, exclude: append(append([]string{}, systemJunk...), excludeGlobs...)}
+
+	for _, g := range append(append([]string{}, includeGlobs...), excludeGlobs...) {
+		if _, err = path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad glob %q: %v", g, err)
+		}
+	}
+
+	if len(onlyKinds) > 0 {
+		f.exts = make(map[string]bool)
+		for _, kind := range onlyKinds {
+			kind = strings.ToLower(strings.TrimSpace(kind))
+			exts, ok := fileKinds[kind]
+			if !ok {
+				exts = []string{"." + strings.TrimPrefix(kind, ".")}
+			}
+			for _, ext := range exts {
+				f.exts[ext] = true
+			}
+		}
+	}
+
+	if minSize != "" {
+		if f.minSize, err = humanize.ParseBytes(minSize); err != nil {
+			return nil, fmt.Errorf("bad --min-size: %v", err)
+		}
+	}
+	if maxSize != "" {
+		if f.maxSize, err = humanize.ParseBytes(maxSize); err != nil {
+			return nil, fmt.Errorf("bad --max-size: %v", err)
+		}
+	}
+
+	if since != "" {
+		if f.since, err = parseDate(since); err != nil {
+			return nil, fmt.Errorf("--since must be a date like 2017-02-13")
+		}
+	}
+	if until != "" {
+		if f.until, err = parseDate(until); err != nil {
+			return nil, fmt.Errorf("--until must be a date like 2017-02-13")
+		}
+		f.until = f.until.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// matchAny reports whether the base name or the slash separated relative
+// path rel matches one of globs.
+func matchAny(globs []string, rel string) bool {
+	base := path.Base(rel)
+	for _, g := range globs {
+		if ok, _ := path.Match(g, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(g, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skip reports whether the file at rel is left out by the flags.
+// Directories are only checked against --exclude.
+func (f *fileFilter) skip(file string, rel string, info os.FileInfo) (bool, error) {
+	if matchAny(f.exclude, rel) {
+		return true, nil
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+
+	if len(f.include) > 0 && !matchAny(f.include, rel) {
+		return true, nil
+	}
+	if f.exts != nil && !f.exts[strings.ToLower(filepath.Ext(rel))] {
+		return true, nil
+	}
+
+	size := uint64(info.Size())
+	if size < f.minSize || (f.maxSize > 0 && size > f.maxSize) {
+		return true, nil
+	}
+
+	if !f.since.IsZero() || !f.until.IsZero() {
+		t, err := clientModified(file, info)
+		if err != nil {
+			return false, err
+		}
+		if (!f.since.IsZero() && t.Before(f.since)) || (!f.until.IsZero() && !t.Before(f.until)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ignoreRule is one pattern of a .boxitignore file.
+type ignoreRule struct {
+	base    string
+	pattern []string
+	negate  bool
+	dirOnly bool
+	rooted  bool
+}
+
+// readIgnoreFile parses the gitignore-style file at filePath, whose
+// patterns apply below base. A missing file has no rules.
+func readIgnoreFile(filePath string, base string) (rules []ignoreRule, err error) {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// As in gitignore, a pattern with a slash other than at the end is
+		// matched from the directory of the ignore file.
+		r.rooted = strings.Contains(line, "/")
+		r.pattern = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		if line != "" {
+			rules = append(rules, r)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// matchSegments matches path segments against pattern segments, where **
+// stands for any number of segments.
+func matchSegments(pattern []string, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segs[1:])
+}
+
+func (r ignoreRule) match(rel string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+
+	segs := strings.Split(rel, "/")
+	if r.rooted {
+		return matchSegments(r.pattern, segs)
+	}
+	return matchSegments(r.pattern, segs[len(segs)-1:])
+}
+
+// selector decides which files below one source directory are uploaded:
+// those that pass the filter flags and are not ignored by a .boxitignore in
+// the config directory or in the source tree.
+type selector struct {
+	root   string
+	filter *fileFilter
+	rules  []ignoreRule
+}
+
+func newSelector(root string) (s *selector, err error) {
+	s = &selector{root: root, filter: filters}
+	if s.filter == nil {
+		s.filter = &fileFilter{exclude: systemJunk}
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return
+	}
+	s.rules, err = readIgnoreFile(path.Join(dir, ignoreFileName), "")
+	return
+}
+
+// skip reports whether file is left out. When it is a directory, the
+// .boxitignore in it is loaded for the files below.
+func (s *selector) skip(file string, info os.FileInfo) (skipped bool, err error) {
+	rel, err := filepath.Rel(s.root, file)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+
+	if rel != "." {
+		if info.Name() == ignoreFileName || info.Name() == cardMarkerName {
+			return true, nil
+		}
+		if skipped, err = s.filter.skip(file, rel, info); skipped || err != nil {
+			return
+		}
+
+		ignored := false
+		for _, r := range s.rules {
+			if r.match(rel, info.IsDir()) {
+				ignored = !r.negate
+			}
+		}
+		if ignored {
+			return true, nil
+		}
+	}
+
+	if info.IsDir() {
+		base := rel
+		if base == "." {
+			base = ""
+		}
+		rules, err := readIgnoreFile(filepath.Join(file, ignoreFileName), base)
+		if err != nil {
+			return false, err
+		}
+		s.rules = append(s.rules, rules...)
+	}
+	return
+}
+
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&includeGlobs, "include", nil, "only upload files whose name or relative path matches one of these globs")
+	cmd.Flags().StringSliceVar(&excludeGlobs, "exclude", nil, "skip files and folders whose name or relative path matches one of these globs")
+	cmd.Flags().StringSliceVar(&onlyKinds, "only", nil, "only upload these kinds of files: raw, jpeg, heif, video or file extensions")
+	cmd.Flags().StringVar(&minSize, "min-size", "", "skip files smaller than this, e.g. 100KB")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "skip files larger than this, e.g. 4GB")
+	cmd.Flags().StringVar(&since, "since", "", "skip files modified before this date (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&until, "until", "", "skip files modified after this date (YYYY-MM-DD)")
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.jpg", "a.jpg", true},
+		{"*.jpg", "a.cr3", false},
+		{"*.jpg", "dir/a.jpg", false},
+		{"dir/*.jpg", "dir/a.jpg", true},
+		{"dir/*.jpg", "dir/sub/a.jpg", false},
+		{"**/cache", "cache", true},
+		{"**/cache", "a/b/cache", true},
+		{"**/cache", "a/cached", false},
+		{"raw/**", "raw/a", true},
+		{"raw/**", "raw/a/b/c", true},
+		{"raw/**", "raw", true},
+		{"raw/**", "rawer/a", false},
+		{"raw/**/*.xmp", "raw/a.xmp", true},
+		{"raw/**/*.xmp", "raw/1/2/a.xmp", true},
+		{"raw/**/*.xmp", "raw/1/2/a.cr3", false},
+		{"**/**/a", "a", true},
+		{"**", "", true},
+		{"a", "", false},
+	}
+
+	for _, tt := range tests {
+		var segs []string
+		if tt.path != "" {
+			segs = strings.Split(tt.path, "/")
+		}
+		if got := matchSegments(strings.Split(tt.pattern, "/"), segs); got != tt.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boxit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		ignoreFileName:          "# temporary files\n*.tmp\n!keep.tmp\n**/cache/\n/private\nraw/**/*.xmp\n",
+		"sub/" + ignoreFileName: "draft*\n!*.tmp\n",
+
+		"a.jpg":                 "",
+		"b.tmp":                 "",
+		"keep.tmp":              "",
+		"draft.jpg":             "",
+		"cache/x.jpg":           "",
+		"deep/cache/y.jpg":      "",
+		"deep/cache.jpg":        "",
+		"private/p.jpg":         "",
+		"sub/private/q.jpg":     "",
+		"sub/draft.jpg":         "",
+		"sub/c.tmp":             "",
+		"raw/c.xmp":             "",
+		"raw/1/2/c.xmp":         "",
+		"raw/c.cr3":             "",
+		".DS_Store":             "",
+		"DCIM/100CANON/IMG.JPG": "",
+		"DCIM/100CANON/MVI.THM": "",
+		"DCIM/CANONMSC/M01.CTG": "",
+		"DCIM/THMBNL/t.jpg":     "",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &selector{root: dir, filter: &fileFilter{exclude: systemJunk}}
+	var got []string
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		skipped, err := s.skip(file, info)
+		if err != nil {
+			return err
+		}
+		if skipped && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if !skipped && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, file)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"DCIM/100CANON/IMG.JPG",
+		"a.jpg",
+		"deep/cache.jpg",
+		"draft.jpg",
+		"keep.tmp",
+		"raw/c.cr3",
+		"sub/c.tmp",
+		"sub/private/q.jpg",
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
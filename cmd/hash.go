@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+)
+
+const hashBlockSize = 4 << 20
+
+// contentHasher computes the Dropbox content hash of what is written to it:
+// the SHA-256 of the concatenated SHA-256 digests of every 4 MB block.
+type contentHasher struct {
+	overall hash.Hash
+	block   hash.Hash
+	inBlock int64
+	offset  int64
+	gap     bool
+}
+
+func newContentHasher() *contentHasher {
+	return &contentHasher{overall: sha256.New(), block: sha256.New()}
+}
+
+func (h *contentHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		m := hashBlockSize - h.inBlock
+		if m > int64(len(p)) {
+			m = int64(len(p))
+		}
+		h.block.Write(p[:m])
+		h.inBlock += m
+		p = p[m:]
+		if h.inBlock == hashBlockSize {
+			h.overall.Write(h.block.Sum(nil))
+			h.block.Reset()
+			h.inBlock = 0
+		}
+	}
+	h.offset += int64(n)
+	return n, nil
+}
+
+// writeAt adds p, read at offset, to the hash. Data hashed already, as when
+// a chunk is read again for a retry, is left out. Data after a gap makes the
+// hash unusable.
+func (h *contentHasher) writeAt(offset int64, p []byte) {
+	if h.gap {
+		return
+	}
+	if offset > h.offset {
+		h.gap = true
+		return
+	}
+	if skip := h.offset - offset; skip < int64(len(p)) {
+		h.Write(p[skip:])
+	}
+}
+
+// sum returns the hash of everything written, or "" when there was a gap.
+// Nothing may be written after it.
+func (h *contentHasher) sum() string {
+	if h.gap {
+		return ""
+	}
+	if h.inBlock > 0 {
+		h.overall.Write(h.block.Sum(nil))
+		h.inBlock = 0
+	}
+	return hex.EncodeToString(h.overall.Sum(nil))
+}
+
+// hashingReader hashes what is read from r, which is at offset pos.
+type hashingReader struct {
+	r   io.Reader
+	h   *contentHasher
+	pos int64
+}
+
+func (hr *hashingReader) Read(p []byte) (n int, err error) {
+	n, err = hr.r.Read(p)
+	hr.h.writeAt(hr.pos, p[:n])
+	hr.pos += int64(n)
+	return
+}
+
+// contentHash computes the Dropbox content hash of r.
+func contentHash(r io.Reader) (string, error) {
+	h := newContentHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return h.sum(), nil
+}
+
+func fileContentHash(src string) (string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return contentHash(f)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// The expected hashes were computed independently, following
+// https://www.dropbox.com/developers/reference/content-hash, with Python's
+// hashlib.
+func TestContentHash(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"short", []byte("hello"), "9595c9df90075148eb06860365df33584b75bff782a510c6cd4883a419833d50"},
+		{"one block", make([]byte, hashBlockSize), "c7e946d101855255d919ef0c70718633adf77d3dfb3adeeecf5d0cb4e951be58"},
+		{"one block and a byte", make([]byte, hashBlockSize+1), "14a4d47f23a30177885d9820122f17d2d3a55fe63f7f5c27b95f689e0b2accd6"},
+		{"three blocks", []byte(strings.Repeat("a", 9<<20)), "9a2f44a2ab5ffffb51f2e9ef81973fef361f2695fdaf70f0131f9b3155ed7289"},
+	}
+
+	for _, tt := range tests {
+		got, err := contentHash(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+
+		// Short reads must not move the block boundaries.
+		got, err = contentHash(iotest.HalfReader(bytes.NewReader(tt.data)))
+		if err != nil || got != tt.want {
+			t.Errorf("%s with short reads: got %s, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestContentHashReadError(t *testing.T) {
+	r := iotest.TimeoutReader(bytes.NewReader(make([]byte, 2*hashBlockSize)))
+	if _, err := contentHash(iotest.HalfReader(r)); err == nil {
+		t.Error("got no error from a failing reader")
+	}
+}
+
+func TestContentHasherWriteAt(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", hashBlockSize/5))
+	want, err := contentHash(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type read struct{ from, to int }
+	tests := []struct {
+		name  string
+		reads []read
+		want  string
+	}{
+		{"in order", []read{{0, 1000}, {1000, len(data)}}, want},
+		{"read again for a retry", []read{{0, 5000}, {1000, 3000}, {2000, len(data)}}, want},
+		{"gap", []read{{0, 1000}, {2000, len(data)}}, ""},
+		{"resumed", []read{{hashBlockSize, len(data)}}, ""},
+	}
+
+	for _, tt := range tests {
+		h := newContentHasher()
+		for _, r := range tt.reads {
+			h.writeAt(int64(r.from), data[r.from:r.to])
+		}
+		if got := h.sum(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyExport string
+	historyCard   string
+)
+
+func history(cmd *cobra.Command, args []string) (err error) {
+	dir, err := configDir()
+	if err != nil {
+		return
+	}
+
+	all, err := (&catalogue{filePath: path.Join(dir, catalogueFileName)}).entries()
+	if err != nil {
+		return
+	}
+
+	var entries []catalogueEntry
+	for _, e := range all {
+		if profileFlag != "" && e.Account != profileFlag && (e.Account != "" || profileFlag != tokenPersonal) {
+			continue
+		}
+		if historyCard != "" && e.Card != historyCard && e.CardLabel != historyCard {
+			continue
+		}
+		if len(args) > 0 && !strings.Contains(e.Source, args[0]) && !strings.Contains(strings.ToLower(e.Path), strings.ToLower(args[0])) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	switch historyExport {
+	case "":
+		for _, e := range entries {
+			card := e.CardLabel
+			if card == "" {
+				card = e.Card
+			}
+			fmt.Printf("%s  %-12s %8s  %s -> %s\n", e.Time.Local().Format("2006-01-02 15:04"), card,
+				humanize.IBytes(uint64(e.Size)), e.Source, e.Path)
+		}
+		fmt.Printf("%d files\n", len(entries))
+	case "json":
+		if entries == nil {
+			entries = []catalogueEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"time", "account", "source", "card", "card_label", "size", "content_hash", "path", "id", "rev"})
+		for _, e := range entries {
+			w.Write([]string{e.Time.Format(time.RFC3339), e.Account, e.Source, e.Card, e.CardLabel, strconv.FormatInt(e.Size, 10),
+				e.ContentHash, e.Path, e.ID, e.Rev})
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		return fmt.Errorf("unknown --export format %q, use csv or json", historyExport)
+	}
+	return
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [filter]",
+	Short: "Show the files boxit has uploaded",
+	Long:  "Show the files boxit has uploaded, optionally only those whose source or Dropbox path contains filter.",
+	RunE:  history,
+	// The catalogue is local, no Dropbox login needed.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyExport, "export", "", "print the history as csv or json")
+	historyCmd.Flags().StringVar(&historyCard, "card", "", "only show files imported from this card, given by its id or volume label")
+	RootCmd.AddCommand(historyCmd)
+}
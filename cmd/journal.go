@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const journalFileName = "sessions.json"
+
+// uploadSession records how far a chunked upload got, so that it can be
+// resumed with UploadSessionAppend after boxit was interrupted.
+type uploadSession struct {
+	SessionID string    `json:"session_id"`
+	Offset    int64     `json:"offset"`
+	Dst       string    `json:"dst"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+// sessionJournal is the on-disk list of unfinished upload sessions, keyed by
+// the absolute path of the source file.
+type sessionJournal struct {
+	mu       sync.Mutex
+	filePath string
+	sessions map[string]uploadSession
+}
+
+var journal *sessionJournal
+
+func openJournal(filePath string) *sessionJournal {
+	j := &sessionJournal{filePath: filePath, sessions: make(map[string]uploadSession)}
+
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return j
+	}
+	if json.Unmarshal(b, &j.sessions) != nil || j.sessions == nil {
+		j.sessions = make(map[string]uploadSession)
+	}
+	return j
+}
+
+// lookup returns the recorded session for src if the source file is still
+// the one it was started for. Stale entries are dropped.
+func (j *sessionJournal) lookup(src string, dst string, info os.FileInfo) (session uploadSession, ok bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	session, ok = j.sessions[src]
+	if !ok {
+		return
+	}
+
+	if session.Dst != dst || session.Size != info.Size() || !session.ModTime.Equal(info.ModTime()) {
+		delete(j.sessions, src)
+		j.save()
+		return uploadSession{}, false
+	}
+	return
+}
+
+func (j *sessionJournal) record(src string, session uploadSession) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.sessions[src] = session
+	return j.save()
+}
+
+func (j *sessionJournal) forget(src string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.sessions[src]; !ok {
+		return nil
+	}
+	delete(j.sessions, src)
+	return j.save()
+}
+
+func (j *sessionJournal) save() error {
+	return writeJSONFile(j.filePath, j.sessions)
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dryRun           bool
+	force            bool
+	maxDeletePercent float64
+)
+
+type deleteBatchArg struct {
+	Entries []*files.DeleteArg `json:"entries"`
+}
+
+type deleteBatchEntry struct {
+	dropbox.Tagged
+	Failure *dropbox.Tagged `json:"failure"`
+}
+
+// deleteBatchResult decodes delete_batch and its check route, which the
+// vendored SDK can not poll for the same reason as finish_batch.
+type deleteBatchResult struct {
+	Tag        string              `json:".tag"`
+	AsyncJobID string              `json:"async_job_id"`
+	Entries    []*deleteBatchEntry `json:"entries"`
+}
+
+// deleteBatch deletes paths with DeleteBatch jobs of up to batchSize
+// entries and returns the paths it failed to delete.
+func deleteBatch(paths []string) (failed []string, err error) {
+	for len(paths) > 0 {
+		n := len(paths)
+		if n > batchSize {
+			n = batchSize
+		}
+		batch := paths[:n]
+		paths = paths[n:]
+
+		var arg deleteBatchArg
+		for _, p := range batch {
+			arg.Entries = append(arg.Entries, files.NewDeleteArg(p))
+		}
+
+		var res deleteBatchResult
+		if err = rpc("delete_batch", arg, &res); err != nil {
+			return
+		}
+
+		jobID := res.AsyncJobID
+		for res.Tag == "async_job_id" || res.Tag == "in_progress" {
+			time.Sleep(batchPollInterval)
+
+			res = deleteBatchResult{}
+			err = rpc("delete_batch/check", map[string]string{"async_job_id": jobID}, &res)
+			if err != nil {
+				return
+			}
+		}
+
+		if res.Tag != "complete" || len(res.Entries) != len(batch) {
+			return nil, fmt.Errorf("batch delete failed: %s", res.Tag)
+		}
+		for i, e := range res.Entries {
+			if e.Tag == "success" {
+				fmt.Printf("deleted  %s\n", batch[i])
+				continue
+			}
+			reason := "unknown error"
+			if e.Failure != nil {
+				reason = e.Failure.Tag
+			}
+			fmt.Fprintf(os.Stderr, "failed   %s: %s\n", batch[i], reason)
+			failed = append(failed, batch[i])
+		}
+	}
+	return
+}
+
+// needsUpload reports whether the remote file at t.dst is missing or has
+// different content than t.src.
+func needsUpload(t uploadTask) (bool, error) {
+	existing := remote.file(t.dst)
+	if existing == nil {
+		return true, nil
+	}
+
+	info, err := os.Stat(t.src)
+	if err != nil {
+		return false, err
+	}
+	if uint64(info.Size()) != existing.Size {
+		return true, nil
+	}
+
+	hash, err := fileContentHash(t.src)
+	return hash != existing.ContentHash, err
+}
+
+func mirror(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 2 {
+		return errors.New("`mirror` requires `src` and `dst` arguments")
+	}
+	src, dst := args[0], path.Clean("/"+args[1])
+
+	if err = openUploadState(); err != nil {
+		return
+	}
+
+	tasks, err := uploadTasks(src, dst)
+	if err != nil {
+		return
+	}
+
+	listed, _, err := listFolder(dst, "")
+	if isNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	var entries, folders []*remoteFile
+	for _, e := range listed {
+		if e.Tag == "file" {
+			entries = append(entries, e)
+		} else if e.Tag == "folder" {
+			folders = append(folders, e)
+		}
+	}
+	remote = newRemoteIndex(entries)
+	onConflict = conflictOverwrite
+
+	keep := make(map[string]bool)
+	var changed []uploadTask
+	for _, t := range tasks {
+		keep[strings.ToLower(t.dst)] = true
+
+		need, err := needsUpload(t)
+		if err != nil {
+			return err
+		}
+		if need {
+			changed = append(changed, t)
+		}
+	}
+
+	var deletes []string
+	for _, e := range entries {
+		if !keep[e.PathLower] {
+			deletes = append(deletes, e.PathDisplay)
+		}
+	}
+	emptied := emptiedFolders(dst, folders, keep)
+
+	if len(entries) > 0 {
+		percent := float64(len(deletes)) * 100 / float64(len(entries))
+		if percent > maxDeletePercent && !force {
+			return fmt.Errorf("refusing to delete %d of %d files (%.0f%%) in %s, use --force to delete anyway",
+				len(deletes), len(entries), percent, dst)
+		}
+	}
+
+	if dryRun {
+		for _, t := range changed {
+			fmt.Printf("upload   %s -> %s\n", t.src, t.dst)
+		}
+		for _, d := range append(deletes, emptied...) {
+			fmt.Printf("delete   %s\n", d)
+		}
+		fmt.Printf("%d to upload, %d unchanged, %d to delete\n", len(changed), len(tasks)-len(changed), len(deletes)+len(emptied))
+		return
+	}
+
+	if len(changed) > 0 {
+		if err = createRemoteDirs(files.New(config), changed); err != nil {
+			return
+		}
+		// Nothing is deleted unless every upload made it.
+		if _, err = uploadAll(changed); err != nil {
+			return
+		}
+	}
+
+	failed, err := deleteBatch(deletes)
+	if err != nil {
+		return
+	}
+	fmt.Printf("%d deleted, %d failed to delete\n", len(deletes)-len(failed), len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("%d files failed to delete", len(failed))
+	}
+
+	// Folders go last, so a folder is only removed once the files in it are.
+	if len(emptied) > 0 {
+		if failed, err = deleteBatch(emptied); err != nil {
+			return
+		}
+		fmt.Printf("%d folders deleted, %d failed to delete\n", len(emptied)-len(failed), len(failed))
+		if len(failed) > 0 {
+			return fmt.Errorf("%d folders failed to delete", len(failed))
+		}
+	}
+	return
+}
+
+// emptiedFolders returns the folders below root that hold none of the kept
+// paths, deepest first.
+func emptiedFolders(root string, folders []*remoteFile, keep map[string]bool) (deletes []string) {
+	used := make(map[string]bool)
+	for p := range keep {
+		for dir := path.Dir(p); !used[dir] && dir != "/"; dir = path.Dir(dir) {
+			used[dir] = true
+		}
+	}
+
+	root = strings.ToLower(root)
+	var emptied []*remoteFile
+	for _, f := range folders {
+		if f.PathLower != root && !used[f.PathLower] {
+			emptied = append(emptied, f)
+		}
+	}
+	sort.SliceStable(emptied, func(i, j int) bool {
+		return pathDepth(emptied[i].PathLower) > pathDepth(emptied[j].PathLower)
+	})
+	for _, f := range emptied {
+		deletes = append(deletes, f.PathDisplay)
+	}
+	return
+}
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror <src> <dst>",
+	Short: "Make a Dropbox folder an exact copy of a local directory",
+	RunE:  mirror,
+}
+
+func init() {
+	mirrorCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "print what would be uploaded and deleted without changing anything")
+	mirrorCmd.Flags().BoolVar(&force, "force", false, "delete even more than --max-delete percent of the remote files")
+	mirrorCmd.Flags().Float64Var(&maxDeletePercent, "max-delete", 10, "refuse to delete more than this percentage of the remote files")
+	mirrorCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "number of files to upload in parallel")
+	RootCmd.AddCommand(mirrorCmd)
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmptiedFolders(t *testing.T) {
+	var folders []*remoteFile
+	for _, p := range []string{"/Archive", "/Archive/Old", "/Archive/Old/Deeper", "/Archive/Kept", "/Archive/Kept/Empty", "/Archive/Kept/Sub"} {
+		folders = append(folders, &remoteFile{Tag: "folder", PathLower: strings.ToLower(p), PathDisplay: p})
+	}
+	keep := map[string]bool{"/archive/kept/sub/a.jpg": true, "/archive/b.jpg": true}
+
+	got := emptiedFolders("/Archive", folders, keep)
+	want := []string{"/Archive/Old/Deeper", "/Archive/Kept/Empty", "/Archive/Old"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := emptiedFolders("/Archive", folders[:1], nil); got != nil {
+		t.Errorf("the root was deleted: %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"github.com/dustin/go-humanize"
+)
+
+var planJSON bool
+
+type planEntry struct {
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Action string `json:"action"`
+	Size   int64  `json:"size"`
+	Same   string `json:"same_as,omitempty"`
+}
+
+// uploadPlan is what `upload --dry-run` reports: the action for every file
+// and the folders that would be created.
+type uploadPlan struct {
+	Files         []planEntry `json:"files"`
+	CreateFolders []string    `json:"create_folders"`
+	UploadFiles   int         `json:"upload_files"`
+	UploadBytes   int64       `json:"upload_bytes"`
+	SkipFiles     int         `json:"skip_files"`
+	SkipBytes     int64       `json:"skip_bytes"`
+}
+
+// planUpload works out what uploading tasks would do. It only makes
+// read-only Dropbox calls.
+func planUpload(tasks []uploadTask) (plan *uploadPlan, err error) {
+	plan = &uploadPlan{Files: []planEntry{}, CreateFolders: []string{}}
+
+	dbx := files.New(config)
+	for _, dir := range remoteDirs(tasks) {
+		exists, err := dirExists(dbx, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			plan.CreateFolders = append(plan.CreateFolders, dir)
+		}
+	}
+
+	for _, t := range tasks {
+		info, err := os.Stat(t.src)
+		if err != nil {
+			return nil, err
+		}
+
+		action, same, err := planFile(t.src, info, t.dst)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := planEntry{Src: t.src, Dst: t.dst, Action: action, Size: info.Size()}
+		if same != nil {
+			entry.Same = same.PathDisplay
+		}
+		plan.Files = append(plan.Files, entry)
+
+		switch action {
+		case actionIdentical, actionExists:
+			plan.SkipFiles++
+			plan.SkipBytes += info.Size()
+		case actionFail:
+		default:
+			plan.UploadFiles++
+			plan.UploadBytes += info.Size()
+		}
+	}
+	return
+}
+
+func (plan *uploadPlan) print() error {
+	if planJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	for _, dir := range plan.CreateFolders {
+		fmt.Printf("%-14s %s\n", "create-folder", dir)
+	}
+	for _, f := range plan.Files {
+		if f.Same != "" && f.Same != f.Dst {
+			fmt.Printf("%-14s %s -> %s (same as %s)\n", f.Action, f.Src, f.Dst, f.Same)
+			continue
+		}
+		fmt.Printf("%-14s %s -> %s\n", f.Action, f.Src, f.Dst)
+	}
+	fmt.Printf("%d files to upload (%s), %d to skip (%s), %d folders to create\n",
+		plan.UploadFiles, humanize.IBytes(uint64(plan.UploadBytes)),
+		plan.SkipFiles, humanize.IBytes(uint64(plan.SkipBytes)), len(plan.CreateFolders))
+	return nil
+}
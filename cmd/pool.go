@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+var jobs int
+
+// uploadResult is the outcome of one uploadTask. file is the committed
+// Dropbox file for uploads that succeeded, or the file with identical
+// content a skipped upload was matched with.
+type uploadResult struct {
+	task    uploadTask
+	skipped bool
+	file    *remoteFile
+	err     error
+}
+
+type stageResult struct {
+	uploadResult
+	staged *stagedFile
+}
+
+// uploadErrors aggregates the files that failed during uploadAll.
+type uploadErrors []uploadResult
+
+func (e uploadErrors) Error() string {
+	if len(e) == 1 {
+		return fmt.Sprintf("%s: %v", e[0].task.src, e[0].err)
+	}
+	return fmt.Sprintf("%d files failed to upload", len(e))
+}
+
+// uploadAll uploads tasks with a pool of `jobs` workers, commits them in
+// batches as they finish, reports the outcome of every file and returns an
+// uploadErrors if any of them failed.
+func uploadAll(tasks []uploadTask) (done []uploadResult, err error) {
+	workers := jobs
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	sizes := make(map[string]int64)
+	var size int64
+	for _, t := range tasks {
+		if info, err := os.Stat(t.src); err == nil {
+			sizes[t.src] = info.Size()
+			size += info.Size()
+		}
+	}
+	p := startProgress("uploaded", len(tasks), size)
+
+	queue := make(chan uploadTask)
+	results := make(chan stageResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				staged, same, skipped, err := stageFile(t.src, t.dst)
+				p.fileDone(sizes[t.src])
+				results <- stageResult{uploadResult{task: t, skipped: skipped, file: same, err: err}, staged}
+			}
+		}()
+	}
+
+	go func() {
+		for _, t := range tasks {
+			queue <- t
+		}
+		close(queue)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed uploadErrors
+	uploaded, skipped := 0, 0
+	report := func(r uploadResult) {
+		done = append(done, r)
+		switch {
+		case r.err != nil:
+			logf(os.Stderr, "failed   %s: %v\n", r.task.src, r.err)
+			failed = append(failed, r)
+		case r.skipped:
+			skipped++
+		default:
+			logf(os.Stdout, "uploaded %s -> %s\n", r.task.src, r.file.PathDisplay)
+			uploaded++
+		}
+
+		// Files skipped because Dropbox already has them are recorded too,
+		// so that the card they are on counts as imported.
+		if uploads != nil && r.err == nil && r.file != nil {
+			if err := uploads.record(r.task.src, r.file); err != nil {
+				logf(os.Stderr, "could not record %s in the catalogue: %v\n", r.task.src, err)
+			}
+		}
+
+		if sweeper != nil && r.err == nil && r.file != nil {
+			if err := sweeper.remove(r.task.src, r.file); err != nil {
+				logf(os.Stderr, "not removing %s: %v\n", r.task.src, err)
+			}
+		}
+	}
+
+	var pending []*stagedFile
+	for r := range results {
+		if r.staged == nil {
+			report(r.uploadResult)
+			continue
+		}
+
+		pending = append(pending, r.staged)
+		if len(pending) == batchSize {
+			for _, c := range commitStaged(pending) {
+				report(c)
+			}
+			pending = nil
+		}
+	}
+	for _, c := range commitStaged(pending) {
+		report(c)
+	}
+
+	p.finish()
+	fmt.Printf("%d uploaded, %d skipped, %d failed\n", uploaded, skipped, len(failed))
+	if len(failed) > 0 {
+		return done, failed
+	}
+	return done, nil
+}
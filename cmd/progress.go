@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	redrawInterval = 200 * time.Millisecond
+	logInterval    = 10 * time.Second
+	barWidth       = 30
+	labelWidth     = 32
+)
+
+// progress draws the state of a running upload or download: one line per
+// file being transferred and a line for the whole run. When stderr is not a
+// terminal it prints a summary line every logInterval instead.
+type progress struct {
+	mu         sync.Mutex
+	tty        bool
+	verb       string
+	totalFiles int
+	doneFiles  int
+	totalBytes int64
+	doneBytes  int64
+	moved      int64
+	active     []*transfer
+	lines      int
+	start      time.Time
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// transfer is one file in flight.
+type transfer struct {
+	p     *progress
+	label string
+	size  int64
+	done  int64
+}
+
+var display *progress
+
+// startProgress sets up display for files totalling size bytes. verb names
+// the transfer, as in "uploaded".
+func startProgress(verb string, files int, size int64) *progress {
+	p := &progress{
+		tty:        isTerminal(os.Stderr),
+		verb:       verb,
+		totalFiles: files,
+		totalBytes: size,
+		start:      time.Now(),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	interval := logInterval
+	if p.tty {
+		interval = redrawInterval
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				if p.tty {
+					p.redraw()
+				} else {
+					fmt.Fprintln(os.Stderr, p.summary())
+				}
+				p.mu.Unlock()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+
+	display = p
+	return p
+}
+
+// finish stops drawing and clears the progress lines.
+func (p *progress) finish() {
+	close(p.stop)
+	<-p.stopped
+
+	p.mu.Lock()
+	p.clear()
+	p.mu.Unlock()
+	display = nil
+}
+
+// logf prints a message to w without garbling the progress lines.
+func logf(w io.Writer, format string, a ...interface{}) {
+	p := display
+	if p == nil || !p.tty {
+		fmt.Fprintf(w, format, a...)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprintf(w, format, a...)
+	p.redraw()
+}
+
+// begin starts showing a transfer of size bytes, offset of which are
+// already done. It returns nil when there is no display.
+func (p *progress) begin(label string, size int64, offset int64) *transfer {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := &transfer{p: p, label: label, size: size, done: offset}
+	p.active = append(p.active, t)
+	p.doneBytes += offset
+	return t
+}
+
+// fileDone counts a file of size bytes as finished, whether it was
+// transferred, skipped or failed.
+func (p *progress) fileDone(size int64) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doneFiles++
+	p.doneBytes += size
+}
+
+// set moves the transfer to offset, as after seeking back for a retry.
+func (t *transfer) set(offset int64) {
+	if t == nil {
+		return
+	}
+
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.p.doneBytes += offset - t.done
+	t.done = offset
+}
+
+func (t *transfer) add(n int64) {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	t.done += n
+	t.p.doneBytes += n
+	t.p.moved += n
+}
+
+// end removes the transfer from the display. Its file is counted by
+// fileDone. Calling end again does nothing.
+func (t *transfer) end() {
+	if t == nil {
+		return
+	}
+
+	p := t.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, a := range p.active {
+		if a == t {
+			p.active = append(p.active[:i], p.active[i+1:]...)
+			p.doneBytes -= t.done
+			break
+		}
+	}
+}
+
+type transferReader struct {
+	r io.Reader
+	t *transfer
+}
+
+func (tr *transferReader) Read(b []byte) (n int, err error) {
+	n, err = tr.r.Read(b)
+	tr.t.add(int64(n))
+	return
+}
+
+// reader counts what is read from r towards the transfer.
+func (t *transfer) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &transferReader{r: r, t: t}
+}
+
+func bar(done int64, total int64) string {
+	n := barWidth
+	if total > 0 && done < total {
+		n = int(done * barWidth / total)
+	}
+	return "[" + strings.Repeat("=", n) + strings.Repeat(" ", barWidth-n) + "]"
+}
+
+// summary describes the whole run, with the rate and ETA based on the bytes
+// actually transferred so far.
+func (p *progress) summary() string {
+	s := fmt.Sprintf("%d/%d files %s, %s/%s", p.doneFiles, p.totalFiles, p.verb,
+		humanize.IBytes(uint64(p.doneBytes)), humanize.IBytes(uint64(p.totalBytes)))
+
+	elapsed := time.Since(p.start).Seconds()
+	if p.moved > 0 && elapsed > 0 {
+		rate := float64(p.moved) / elapsed
+		s += fmt.Sprintf(", %s/s", humanize.IBytes(uint64(rate)))
+		if left := p.totalBytes - p.doneBytes; left > 0 {
+			eta := time.Duration(float64(left) / rate * float64(time.Second))
+			s += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+	}
+	return s
+}
+
+// clear erases the lines drawn by redraw. p.mu must be held.
+func (p *progress) clear() {
+	for ; p.lines > 0; p.lines-- {
+		fmt.Fprint(os.Stderr, "\x1b[1A\x1b[2K")
+	}
+}
+
+// redraw draws a line per active transfer and the total. p.mu must be held.
+func (p *progress) redraw() {
+	p.clear()
+	for _, t := range p.active {
+		label := t.label
+		if len(label) > labelWidth {
+			label = "..." + label[len(label)-labelWidth+3:]
+		}
+		fmt.Fprintf(os.Stderr, "%s %-*s %s/%s\n", bar(t.done, t.size), labelWidth, label,
+			humanize.IBytes(uint64(t.done)), humanize.IBytes(uint64(t.size)))
+	}
+	fmt.Fprintf(os.Stderr, "%s %s\n", bar(p.doneBytes, p.totalBytes), p.summary())
+	p.lines = len(p.active) + 1
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = time.Minute
+)
+
+var (
+	maxRetries   int
+	retryTimeout time.Duration
+)
+
+// transientError is returned by retryTransport for responses that are worth
+// retrying: rate limits and server errors.
+type transientError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e *transientError) Error() string {
+	return fmt.Sprintf("dropbox: %s", strings.ToLower(http.StatusText(e.status)))
+}
+
+// retryTransport turns 429 and 5xx responses into a transientError carrying
+// the Retry-After delay, which the SDK would otherwise hide from us.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+		return resp, nil
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	e := &transientError{status: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return nil, e
+}
+
+// retryable reports whether err is temporary and how long the server asked
+// us to wait before trying again, if it did.
+func retryable(err error) (wait time.Duration, ok bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || isConnectionLost(err) {
+		return 0, true
+	}
+
+	if e, isURL := err.(*url.Error); isURL {
+		err = e.Err
+	}
+
+	switch e := err.(type) {
+	case *transientError:
+		return e.retryAfter, true
+	case net.Error:
+		if e.Timeout() || e.Temporary() {
+			return 0, true
+		}
+	}
+
+	msg := err.Error()
+	return 0, strings.Contains(msg, "too_many_requests") ||
+		strings.Contains(msg, "too_many_write_operations") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "broken pipe")
+}
+
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retry calls fn until it succeeds, fails permanently, or --max-retries or
+// --retry-timeout is exhausted. fn must be safe to call again, e.g. by
+// rewinding the reader it uploads from.
+func retry(what string, fn func() error) (err error) {
+	deadline := time.Now().Add(retryTimeout)
+
+	for attempt := 0; ; attempt++ {
+		err = fn()
+
+		wait, ok := retryable(err)
+		if !ok || attempt >= maxRetries {
+			return
+		}
+		if wait == 0 {
+			wait = backoff(attempt)
+		}
+		if retryTimeout > 0 && time.Now().Add(wait).After(deadline) {
+			return
+		}
+
+		logf(os.Stderr, "%s: %v, retrying in %s\n", what, err, wait)
+		time.Sleep(wait)
+	}
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
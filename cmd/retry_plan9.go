@@ -0,0 +1,7 @@
+package cmd
+
+// isConnectionLost has no error numbers to go by on Plan 9. The messages are
+// matched by retryable instead.
+func isConnectionLost(err error) bool {
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build !plan9
+// +build !plan9
+
+package cmd
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isConnectionLost reports whether err, however deeply wrapped, is the
+// connection being reset or closed while writing to it.
+func isConnectionLost(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,42 @@
+//go:build !plan9
+// +build !plan9
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://content.dropboxapi.com/2/files/upload_session/append_v2",
+			Err: &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", err)}}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"broken pipe", wrap(syscall.EPIPE), true},
+		{"connection reset", wrap(syscall.ECONNRESET), true},
+		{"connection refused", wrap(syscall.ECONNREFUSED), false},
+		{"unexpected EOF", &url.Error{Op: "Post", Err: io.ErrUnexpectedEOF}, true},
+		{"rate limited", &transientError{status: 429}, true},
+		{"too many write operations", errors.New("path/too_many_write_operations/"), true},
+		{"not found", errors.New("path/not_found/"), false},
+	}
+
+	for _, tt := range tests {
+		if _, got := retryable(tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
 	"github.com/mitchellh/go-homedir"
@@ -17,94 +20,124 @@ import (
 const (
 	configFileName = "auth.json"
 	appKey         = "sdjksdjkfhsdjkd"
-	appSecret      = "23kj423jk2k3j4j"
 	dropboxScheme  = "dropbox"
 
 	tokenPersonal = "personal"
 )
 
-type TokenMap map[string]map[string]string
+// storedToken is an OAuth token as kept in the token file. Files written by
+// older versions hold only the access token, as a string.
+type storedToken oauth2.Token
+
+func (t *storedToken) UnmarshalJSON(b []byte) error {
+	var access string
+	if json.Unmarshal(b, &access) == nil {
+		*t = storedToken{AccessToken: access}
+		return nil
+	}
+	return json.Unmarshal(b, (*oauth2.Token)(t))
+}
+
+type TokenMap map[string]map[string]*storedToken
 
 var config dropbox.Config
 
+// readTokens reads the token file. A missing file holds no tokens.
 func readTokens(filePath string) (TokenMap, error) {
 	b, err := ioutil.ReadFile(filePath)
-
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	var tokens TokenMap
-	if json.Unmarshal(b, &tokens) != nil {
-		return nil, err
+	if err = json.Unmarshal(b, &tokens); err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
 	}
 	return tokens, nil
 }
 
-func writeTokens(filePath string, tokens TokenMap) {
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		err = os.MkdirAll(filepath.Dir(filePath), 0700)
-		if err != nil {
-			return
-		}
+// writeTokens replaces the token file atomically, so that a crash while a
+// refreshed token is saved can not lose every account.
+func writeTokens(filePath string, tokens TokenMap) error {
+	return writeJSONFile(filePath, tokens)
+}
+
+// writeJSONFile writes v to a temporary file and renames it into place, so
+// that a crash never leaves a truncated state file behind.
+func writeJSONFile(filePath string, v interface{}) (err error) {
+	if err = os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return
 	}
 
-	b, err := json.Marshal(tokens)
+	b, err := json.Marshal(v)
 	if err != nil {
 		return
 	}
 
-	if err = ioutil.WriteFile(filePath, b, 0600); err != nil {
+	tmp := filePath + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
 		return
 	}
-
+	return os.Rename(tmp, filePath)
 }
 
-func initDbx(cmd *cobra.Command, args []string) (err error) {
+// configDir returns the directory boxit keeps its tokens and state in.
+func configDir() (string, error) {
 	dir, err := homedir.Dir()
-	domain := ""
-
 	if err != nil {
-		return
+		return "", err
 	}
+	return path.Join(dir, ".config", "boxit"), nil
+}
 
-	configFilePath := path.Join(dir, ".config", "boxit", configFileName)
-	conf := &oauth2.Config{
-		ClientID:     appKey,
-		ClientSecret: appSecret,
-		Endpoint:     dropbox.OAuthEndpoint(domain),
+func initDbx(cmd *cobra.Command, args []string) (err error) {
+	if bandwidth, err = parseBwLimit(bwLimit); err != nil {
+		return
 	}
 
-	tokenMap, err := readTokens(configFilePath)
-
-	if tokenMap == nil {
-		tokenMap = make(TokenMap)
+	tokenMap, profiles, err := loadAccounts()
+	if err != nil {
+		return
 	}
 
-	if tokenMap[domain] == nil {
-		tokenMap[domain] = make(map[string]string)
+	profileName = profiles.current()
+	var token *oauth2.Token
+	if token, tokenOrigin, err = externalToken(); err != nil {
+		return fmt.Errorf("could not read the token from %s: %v", tokenOrigin, err)
 	}
 
-	tokens := tokenMap[domain]
-
-	if err != nil || tokens[tokenPersonal] == "" {
-		fmt.Printf("Go to %v\n", conf.AuthCodeURL("state"))
-
-		var code string
-		if _, err = fmt.Scan(&code); err != nil {
-			return
+	source := &tokenSource{token: token}
+	if token == nil {
+		if token = (*oauth2.Token)(tokenMap[""][profileName]); token == nil {
+			if profileName != tokenPersonal {
+				return fmt.Errorf("not logged in to %s, run `boxit --profile %s auth login`", profileName, profileName)
+			}
+			return errors.New("not logged in, run `boxit auth login`")
 		}
+		source = &tokenSource{profile: profileName, token: token}
+	}
+	if token, err = source.Token(); err != nil {
+		return
+	}
 
-		var token *oauth2.Token
-		token, tokenerr := conf.Exchange(oauth2.NoContext, code)
-		if tokenerr != nil {
+	if s := profiles.Profiles[profileName]; s != nil {
+		profile = s
+		if err = profile.apply(cmd); err != nil {
 			return
 		}
-		tokens[tokenPersonal] = token.AccessToken
-		writeTokens(configFilePath, tokenMap)
 	}
 
-	config = dropbox.Config{tokens[tokenPersonal], false, "", ""}
+	config = dropbox.Config{Token: token.AccessToken}
+
+	// The SDK builds its clients on top of http.DefaultClient, which makes
+	// its transport the one place to see rate limit responses and to renew
+	// the access token.
+	http.DefaultClient.Transport = &retryTransport{
+		base: &authTransport{base: http.DefaultTransport, source: source},
+	}
 
 	return
 }
@@ -117,6 +150,13 @@ var RootCmd = &cobra.Command{
 	PersistentPreRunE: initDbx,
 }
 
+func init() {
+	RootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", 5, "retries for rate limited or failed Dropbox requests")
+	RootCmd.PersistentFlags().DurationVar(&retryTimeout, "retry-timeout", 10*time.Minute, "give up retrying a request after this long")
+	RootCmd.PersistentFlags().StringVar(&profileFlag, "profile", "", "account to use, as set up with boxit account add")
+	RootCmd.PersistentFlags().StringVar(&bwLimit, "bwlimit", "", "limit the total upload rate, e.g. 2MB or \"08:00-18:00 2MB, 10MB\" for a limit by time of day")
+}
+
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
 		os.Exit(-1)
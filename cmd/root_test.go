@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boxit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "auth.json")
+
+	// Older versions kept only the access token, as a string.
+	legacy := `{"": {"personal": "old-access-token"}}`
+	if err = ioutil.WriteFile(file, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := readTokens(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TokenMap{"": {tokenPersonal: {AccessToken: "old-access-token"}}}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("legacy file: got %+v, want %+v", tokens[""][tokenPersonal], want[""][tokenPersonal])
+	}
+
+	tokens[""][tokenPersonal] = &storedToken{
+		AccessToken:  "new-access-token",
+		TokenType:    "bearer",
+		RefreshToken: "refresh-token",
+		Expiry:       time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err = writeTokens(file, tokens); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readTokens(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tokens) {
+		t.Errorf("round trip: got %+v, want %+v", got[""][tokenPersonal], tokens[""][tokenPersonal])
+	}
+}
+
+func TestReadTokensMissing(t *testing.T) {
+	if tokens, err := readTokens(filepath.Join(os.TempDir(), "boxit-no-such-file.json")); tokens != nil || err != nil {
+		t.Errorf("got %v, %v, want no tokens", tokens, err)
+	}
+}
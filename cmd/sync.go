@@ -0,0 +1,531 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"github.com/spf13/cobra"
+)
+
+// How sync resolves files that changed on both sides.
+const (
+	syncNewer  = "newer"
+	syncBoth   = "both"
+	syncPrompt = "prompt"
+)
+
+// syncMinDeletes is how many files a sync may always delete, so that
+// --max-delete does not get in the way in small folders.
+const syncMinDeletes = 10
+
+var syncConflict string
+
+// syncEntry is what a file looked like on both sides after the last sync.
+type syncEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"`
+	Rev     string    `json:"rev"`
+}
+
+// syncState is the state database of one local and Dropbox folder pair,
+// keyed by the lower case relative path of each file.
+type syncState struct {
+	Local  string                `json:"local"`
+	Remote string                `json:"remote"`
+	Cursor string                `json:"cursor"`
+	Files  map[string]*syncEntry `json:"files"`
+}
+
+func syncStatePath(local string, remote string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	key := local + "\x00" + strings.ToLower(remote)
+	if profileName != tokenPersonal {
+		key += "\x00" + profileName
+	}
+	sum := sha1.Sum([]byte(key))
+	return path.Join(dir, "sync", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+func readSyncState(filePath string, local string, remote string) *syncState {
+	state := &syncState{Local: local, Remote: remote}
+	if b, err := ioutil.ReadFile(filePath); err == nil {
+		json.Unmarshal(b, state)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*syncEntry)
+	}
+	return state
+}
+
+type localFile struct {
+	rel  string
+	path string
+	info os.FileInfo
+}
+
+func syncKey(rel string) string {
+	return strings.ToLower(rel)
+}
+
+// scanLocal returns the files below root, skipping partial downloads.
+func scanLocal(root string) (locals map[string]*localFile, err error) {
+	locals = make(map[string]*localFile)
+	err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || (strings.HasPrefix(info.Name(), ".") && strings.HasSuffix(info.Name(), ".part")) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		locals[syncKey(rel)] = &localFile{rel: rel, path: file, info: info}
+		return nil
+	})
+	return
+}
+
+// scanRemote returns the files below the remote folder: from a full listing
+// on the first run, otherwise from the last state and the changes since its
+// cursor.
+func scanRemote(state *syncState) (remotes map[string]*remoteFile, cursor string, err error) {
+	prefix := strings.TrimSuffix(strings.ToLower(state.Remote), "/") + "/"
+	remotes = make(map[string]*remoteFile)
+
+	var entries []*remoteFile
+	if state.Cursor != "" {
+		entries, cursor, err = listFolder(state.Remote, state.Cursor)
+		if err == nil {
+			for key, e := range state.Files {
+				remotes[key] = &remoteFile{
+					Tag:         "file",
+					PathDisplay: path.Join(state.Remote, e.Path),
+					Rev:         e.Rev,
+					Size:        uint64(e.Size),
+					ContentHash: e.Hash,
+				}
+			}
+		} else if !strings.Contains(err.Error(), "reset") {
+			return
+		}
+	}
+
+	if state.Cursor == "" || err != nil {
+		entries, cursor, err = listFolder(state.Remote, "")
+		if isNotFound(err) {
+			return remotes, "", nil
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	for _, e := range entries {
+		key := strings.TrimPrefix(e.PathLower, prefix)
+		switch e.Tag {
+		case "file":
+			remotes[key] = e
+		case "deleted":
+			delete(remotes, key)
+			for k := range remotes {
+				if strings.HasPrefix(k, key+"/") {
+					delete(remotes, k)
+				}
+			}
+		}
+	}
+	return
+}
+
+// relPath returns the path of a remote file relative to the synced folder.
+func (state *syncState) relPath(file *remoteFile) string {
+	return strings.Join(strings.Split(strings.Trim(file.PathDisplay, "/"), "/")[pathDepth(state.Remote):], "/")
+}
+
+// syncRename moves a local file that changed on both sides out of the way
+// of the remote version, keeping both.
+type syncRename struct {
+	from     string
+	to       string
+	upload   *localFile
+	download *remoteFile
+}
+
+// syncPlan is what sync is going to do. Planning changes nothing on either
+// side.
+type syncPlan struct {
+	renames       []*syncRename
+	uploads       []*localFile
+	downloads     []*remoteFile
+	deleteLocal   []string
+	deleteRemote  []string
+	unchanged     map[string]*syncEntry
+	conflictCount int
+}
+
+// drop takes the transfers of a rename that failed out of the plan, so that
+// the remote version does not overwrite the local one.
+func (p *syncPlan) drop(rn *syncRename) {
+	kept := p.uploads[:0]
+	for _, l := range p.uploads {
+		if l != rn.upload {
+			kept = append(kept, l)
+		}
+	}
+	p.uploads = kept
+
+	downloads := p.downloads[:0]
+	for _, r := range p.downloads {
+		if r != rn.download {
+			downloads = append(downloads, r)
+		}
+	}
+	p.downloads = downloads
+}
+
+func localChanged(l *localFile, e *syncEntry) (changed bool, err error) {
+	if e == nil {
+		return true, nil
+	}
+	if l.info.Size() == e.Size && l.info.ModTime().Equal(e.ModTime) {
+		return false, nil
+	}
+
+	hash, err := fileContentHash(l.path)
+	return hash != e.Hash, err
+}
+
+func conflictName(rel string) string {
+	ext := path.Ext(rel)
+	return fmt.Sprintf("%s (conflicted copy %s)%s", strings.TrimSuffix(rel, ext), time.Now().Format("2006-01-02"), ext)
+}
+
+func askConflict(rel string) string {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("%s changed on both sides. Keep [l]ocal, [r]emote or [b]oth? ", rel)
+		answer, err := in.ReadString('\n')
+		if err != nil {
+			return syncBoth
+		}
+		switch strings.TrimSpace(strings.ToLower(answer)) {
+		case "l", "local":
+			return "local"
+		case "r", "remote":
+			return "remote"
+		case "b", "both":
+			return syncBoth
+		}
+	}
+}
+
+// resolve decides a file that changed on both sides.
+func (p *syncPlan) resolve(state *syncState, l *localFile, r *remoteFile) {
+	p.conflictCount++
+
+	choice := syncConflict
+	if choice == syncPrompt {
+		choice = askConflict(l.rel)
+	}
+	if choice == syncNewer {
+		choice = "remote"
+		if l.info.ModTime().After(r.ClientModified) {
+			choice = "local"
+		}
+	}
+
+	switch choice {
+	case "local":
+		p.uploads = append(p.uploads, l)
+	case "remote":
+		p.downloads = append(p.downloads, r)
+	default:
+		rel := conflictName(l.rel)
+		renamed := &localFile{rel: rel, path: filepath.Join(state.Local, filepath.FromSlash(rel)), info: l.info}
+		p.renames = append(p.renames, &syncRename{from: l.path, to: renamed.path, upload: renamed, download: r})
+		p.uploads = append(p.uploads, renamed)
+		p.downloads = append(p.downloads, r)
+	}
+}
+
+func planSync(state *syncState, locals map[string]*localFile, remotes map[string]*remoteFile) (p *syncPlan, err error) {
+	p = &syncPlan{unchanged: make(map[string]*syncEntry)}
+
+	keys := make(map[string]bool)
+	for k := range locals {
+		keys[k] = true
+	}
+	for k := range remotes {
+		keys[k] = true
+	}
+	for k := range state.Files {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		l, r, e := locals[key], remotes[key], state.Files[key]
+		remoteChanged := r != nil && (e == nil || r.Rev != e.Rev)
+
+		switch {
+		case l != nil && r != nil:
+			lc, err := localChanged(l, e)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case lc && remoteChanged:
+				hash, err := fileContentHash(l.path)
+				if err != nil {
+					return nil, err
+				}
+				if hash == r.ContentHash {
+					p.unchanged[key] = &syncEntry{Path: l.rel, Size: l.info.Size(), ModTime: l.info.ModTime(), Hash: hash, Rev: r.Rev}
+				} else {
+					p.resolve(state, l, r)
+				}
+			case lc:
+				p.uploads = append(p.uploads, l)
+			case remoteChanged:
+				p.downloads = append(p.downloads, r)
+			}
+		case l != nil:
+			lc, err := localChanged(l, e)
+			if err != nil {
+				return nil, err
+			}
+			if e == nil || lc {
+				p.uploads = append(p.uploads, l)
+			} else {
+				p.deleteLocal = append(p.deleteLocal, key)
+			}
+		case r != nil:
+			if e == nil || remoteChanged {
+				p.downloads = append(p.downloads, r)
+			} else {
+				p.deleteRemote = append(p.deleteRemote, key)
+			}
+		}
+	}
+	return
+}
+
+// checkSyncDeletes refuses to delete more than --max-delete percent of the
+// known files, unless that is no more than syncMinDeletes or --force is
+// given.
+func checkSyncDeletes(deletes int, known int) error {
+	if force || deletes <= syncMinDeletes || known == 0 {
+		return nil
+	}
+	if percent := float64(deletes) * 100 / float64(known); percent > maxDeletePercent {
+		return fmt.Errorf("refusing to delete %d of %d synced files (%.0f%%), use --force to delete anyway",
+			deletes, known, percent)
+	}
+	return nil
+}
+
+func deleteRemoteFile(dst string) error {
+	dbx := files.New(config)
+	return retry("delete "+dst, func() (err error) {
+		_, err = dbx.Delete(files.NewDeleteArg(dst))
+		if isNotFound(err) {
+			return nil
+		}
+		return
+	})
+}
+
+func syncRun(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 2 {
+		return errors.New("`sync` requires `local-dir` and `dropbox-path` arguments")
+	}
+	if syncConflict != syncNewer && syncConflict != syncBoth && syncConflict != syncPrompt {
+		return fmt.Errorf("--conflict must be %s, %s or %s", syncNewer, syncBoth, syncPrompt)
+	}
+	if syncConflict == syncPrompt && !interactive() {
+		return fmt.Errorf("--conflict %s: %v", syncPrompt, errNonInteractive)
+	}
+
+	local, err := filepath.Abs(args[0])
+	if err != nil {
+		return
+	}
+	remoteRoot := path.Clean("/" + args[1])
+
+	statePath, err := syncStatePath(local, remoteRoot)
+	if err != nil {
+		return
+	}
+	state := readSyncState(statePath, local, remoteRoot)
+
+	if err = openUploadState(); err != nil {
+		return
+	}
+
+	// A local root that went away, such as an unmounted disk, would look as
+	// if every file had been deleted locally.
+	if _, err = os.Stat(local); os.IsNotExist(err) && len(state.Files) > 0 {
+		return fmt.Errorf("%s does not exist but was synced before, refusing to delete its files from Dropbox", local)
+	}
+	if err = os.MkdirAll(local, 0755); err != nil {
+		return
+	}
+	locals, err := scanLocal(local)
+	if err != nil {
+		return
+	}
+	remotes, cursor, err := scanRemote(state)
+	if err != nil {
+		return
+	}
+
+	plan, err := planSync(state, locals, remotes)
+	if err != nil {
+		return
+	}
+
+	if err = checkSyncDeletes(len(plan.deleteLocal)+len(plan.deleteRemote), len(state.Files)); err != nil {
+		return
+	}
+
+	failed := 0
+	for key, e := range plan.unchanged {
+		state.Files[key] = e
+	}
+
+	for _, rn := range plan.renames {
+		if err := os.Rename(rn.from, rn.to); err != nil {
+			fmt.Fprintf(os.Stderr, "failed   %s: %v\n", rn.from, err)
+			failed++
+			plan.drop(rn)
+			continue
+		}
+		fmt.Printf("renamed  %s -> %s\n", rn.from, rn.to)
+	}
+
+	for _, key := range plan.deleteLocal {
+		l := locals[key]
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed   %s: %v\n", l.path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("deleted  %s\n", l.path)
+		delete(state.Files, key)
+	}
+
+	for _, key := range plan.deleteRemote {
+		r := remotes[key]
+		if err := deleteRemoteFile(r.PathDisplay); err != nil {
+			fmt.Fprintf(os.Stderr, "failed   %s: %v\n", r.PathDisplay, err)
+			failed++
+			continue
+		}
+		fmt.Printf("deleted  %s\n", r.PathDisplay)
+		delete(state.Files, key)
+	}
+
+	for key := range state.Files {
+		if locals[key] == nil && remotes[key] == nil {
+			delete(state.Files, key)
+		}
+	}
+
+	// Uploads replace what is in Dropbox: planSync already decided that the
+	// local copy wins.
+	onConflict = conflictOverwrite
+	remote = nil
+
+	var toUpload []uploadTask
+	byTask := make(map[string]*localFile)
+	for _, l := range plan.uploads {
+		t := uploadTask{src: l.path, dst: path.Join(remoteRoot, l.rel)}
+		toUpload = append(toUpload, t)
+		byTask[t.src] = l
+	}
+	if len(toUpload) > 0 {
+		done, _ := uploadAll(toUpload)
+		for _, r := range done {
+			l := byTask[r.task.src]
+			if r.err != nil || r.file == nil {
+				failed++
+				continue
+			}
+			state.Files[syncKey(l.rel)] = &syncEntry{Path: l.rel, Size: l.info.Size(), ModTime: l.info.ModTime(), Hash: r.file.ContentHash, Rev: r.file.Rev}
+		}
+	}
+
+	var downloads []downloadTask
+	for _, r := range plan.downloads {
+		downloads = append(downloads, downloadTask{file: r, dst: filepath.Join(local, filepath.FromSlash(state.relPath(r)))})
+	}
+	if len(downloads) > 0 {
+		done, _ := downloadAll(downloads)
+		for _, d := range done {
+			info, err := os.Stat(d.task.dst)
+			if d.err != nil || err != nil {
+				failed++
+				continue
+			}
+			rel := state.relPath(d.task.file)
+			state.Files[syncKey(rel)] = &syncEntry{Path: rel, Size: info.Size(), ModTime: info.ModTime(), Hash: d.task.file.ContentHash, Rev: d.task.file.Rev}
+		}
+	}
+
+	// Remote changes that failed to apply must be seen again next time, so
+	// the cursor only moves on when everything succeeded.
+	if failed == 0 {
+		state.Cursor = cursor
+	}
+	if err = writeJSONFile(statePath, state); err != nil {
+		return
+	}
+
+	fmt.Printf("%d uploaded, %d downloaded, %d deleted locally, %d deleted remotely, %d conflicts\n",
+		len(plan.uploads), len(plan.downloads), len(plan.deleteLocal), len(plan.deleteRemote), plan.conflictCount)
+	if failed > 0 {
+		return fmt.Errorf("%d files failed to sync", failed)
+	}
+	return
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <local-dir> <dropbox-path>",
+	Short: "Synchronise a local folder and a Dropbox folder in both directions",
+	RunE:  syncRun,
+}
+
+func init() {
+	syncCmd.Flags().StringVar(&syncConflict, "conflict", syncBoth, "files changed on both sides: newer (newer wins), both (keep both) or prompt")
+	syncCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "number of files to transfer in parallel")
+	syncCmd.Flags().BoolVar(&force, "force", false, "delete even more than --max-delete percent of the synced files")
+	syncCmd.Flags().Float64Var(&maxDeletePercent, "max-delete", 10, "refuse to delete more than this percentage of the synced files")
+	RootCmd.AddCommand(syncCmd)
+}
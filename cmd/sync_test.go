@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syncSummary is a syncPlan reduced to relative paths, for comparing.
+type syncSummary struct {
+	renames      []string
+	uploads      []string
+	downloads    []string
+	deleteLocal  []string
+	deleteRemote []string
+	unchanged    []string
+	conflicts    int
+}
+
+func summarizeSync(state *syncState, p *syncPlan) (s syncSummary) {
+	for _, rn := range p.renames {
+		s.renames = append(s.renames, filepath.Base(rn.from)+" -> "+filepath.Base(rn.to))
+	}
+	for _, l := range p.uploads {
+		s.uploads = append(s.uploads, l.rel)
+	}
+	for _, r := range p.downloads {
+		s.downloads = append(s.downloads, state.relPath(r))
+	}
+	s.deleteLocal = p.deleteLocal
+	s.deleteRemote = p.deleteRemote
+	for key := range p.unchanged {
+		s.unchanged = append(s.unchanged, key)
+	}
+	for _, list := range [][]string{s.renames, s.uploads, s.downloads, s.deleteLocal, s.deleteRemote, s.unchanged} {
+		sort.Strings(list)
+	}
+	s.conflicts = p.conflictCount
+	return
+}
+
+func TestPlanSync(t *testing.T) {
+	root, err := ioutil.TempDir("", "boxit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	hash := func(content string) string {
+		h, err := contentHash(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	conflicted := conflictName("a.txt")
+
+	tests := []struct {
+		name   string
+		policy string
+		// synced is the content of each file after the last sync, none on
+		// a first run. A remote file whose content is unchanged keeps the
+		// rev of the last sync.
+		synced map[string]string
+		local  map[string]string
+		remote map[string]string
+		want   syncSummary
+	}{
+		{
+			name:   "nothing changed",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "a"},
+			remote: map[string]string{"a.txt": "a"},
+		},
+		{
+			name:   "added locally",
+			local:  map[string]string{"a.txt": "a"},
+			remote: map[string]string{},
+			want:   syncSummary{uploads: []string{"a.txt"}},
+		},
+		{
+			name:   "edited locally",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "a, edited"},
+			remote: map[string]string{"a.txt": "a"},
+			want:   syncSummary{uploads: []string{"a.txt"}},
+		},
+		{
+			name:   "edited locally to the same size",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "b"},
+			remote: map[string]string{"a.txt": "a"},
+			want:   syncSummary{uploads: []string{"a.txt"}},
+		},
+		{
+			name:   "deleted locally",
+			synced: map[string]string{"a.txt": "a", "B.txt": "b"},
+			local:  map[string]string{"B.txt": "b"},
+			remote: map[string]string{"a.txt": "a", "B.txt": "b"},
+			want:   syncSummary{deleteRemote: []string{"a.txt"}},
+		},
+		{
+			name:   "added remotely",
+			local:  map[string]string{},
+			remote: map[string]string{"dir/a.txt": "a"},
+			want:   syncSummary{downloads: []string{"dir/a.txt"}},
+		},
+		{
+			name:   "edited remotely",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "a"},
+			remote: map[string]string{"a.txt": "a, edited"},
+			want:   syncSummary{downloads: []string{"a.txt"}},
+		},
+		{
+			name:   "deleted remotely",
+			synced: map[string]string{"a.txt": "a", "B.txt": "b"},
+			local:  map[string]string{"a.txt": "a", "B.txt": "b"},
+			remote: map[string]string{"a.txt": "a"},
+			want:   syncSummary{deleteLocal: []string{"b.txt"}},
+		},
+		{
+			name:   "deleted on both sides",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{},
+			remote: map[string]string{},
+		},
+		{
+			name:   "deleted locally, edited remotely",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{},
+			remote: map[string]string{"a.txt": "a, edited"},
+			want:   syncSummary{downloads: []string{"a.txt"}},
+		},
+		{
+			name:   "edited locally, deleted remotely",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "a, edited"},
+			remote: map[string]string{},
+			want:   syncSummary{uploads: []string{"a.txt"}},
+		},
+		{
+			name:   "edited on both sides, keep both",
+			policy: syncBoth,
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "a, edited locally"},
+			remote: map[string]string{"a.txt": "a, edited remotely"},
+			want: syncSummary{
+				renames:   []string{"a.txt -> " + conflicted},
+				uploads:   []string{conflicted},
+				downloads: []string{"a.txt"},
+				conflicts: 1,
+			},
+		},
+		{
+			name:   "edited on both sides, newer wins",
+			policy: syncNewer,
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "a, edited locally"},
+			remote: map[string]string{"a.txt": "a, edited remotely"},
+			want:   syncSummary{uploads: []string{"a.txt"}, conflicts: 1},
+		},
+		{
+			name:   "edited the same way on both sides",
+			synced: map[string]string{"a.txt": "a"},
+			local:  map[string]string{"a.txt": "a, edited"},
+			remote: map[string]string{"a.txt": "a, edited"},
+			want:   syncSummary{unchanged: []string{"a.txt"}},
+		},
+		{
+			name:   "first run with files on both sides",
+			policy: syncBoth,
+			local:  map[string]string{"a.txt": "local", "same.txt": "same", "local.txt": "l"},
+			remote: map[string]string{"a.txt": "remote", "same.txt": "same", "remote.txt": "r"},
+			want: syncSummary{
+				renames:   []string{"a.txt -> " + conflicted},
+				uploads:   []string{conflicted, "local.txt"},
+				downloads: []string{"a.txt", "remote.txt"},
+				unchanged: []string{"same.txt"},
+				conflicts: 1,
+			},
+		},
+	}
+
+	defer func(policy string) { syncConflict = policy }(syncConflict)
+	// Remote files were changed an hour ago, before the local edits.
+	remoteModified := time.Now().Add(-time.Hour)
+
+	for i, tt := range tests {
+		syncConflict = tt.policy
+		if syncConflict == "" {
+			syncConflict = syncBoth
+		}
+
+		dir := filepath.Join(root, strings.Repeat("d", i+1))
+		state := &syncState{Local: dir, Remote: "/Sync", Files: make(map[string]*syncEntry)}
+		locals := make(map[string]*localFile)
+		remotes := make(map[string]*remoteFile)
+
+		for rel, content := range tt.local {
+			file := filepath.Join(dir, filepath.FromSlash(rel))
+			if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			locals[syncKey(rel)] = &localFile{rel: rel, path: file, info: info}
+		}
+
+		for rel, content := range tt.synced {
+			e := &syncEntry{Path: rel, Size: int64(len(content)), Hash: hash(content), Rev: "synced"}
+			if l := locals[syncKey(rel)]; l != nil && tt.local[rel] == content {
+				e.ModTime = l.info.ModTime()
+			}
+			state.Files[syncKey(rel)] = e
+		}
+
+		for rel, content := range tt.remote {
+			rev := "edited"
+			if synced, ok := tt.synced[rel]; ok && synced == content {
+				rev = "synced"
+			}
+			remotes[syncKey(rel)] = &remoteFile{
+				Tag:            "file",
+				PathDisplay:    path.Join(state.Remote, rel),
+				Rev:            rev,
+				Size:           uint64(len(content)),
+				ContentHash:    hash(content),
+				ClientModified: remoteModified,
+			}
+		}
+
+		p, err := planSync(state, locals, remotes)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := summarizeSync(state, p); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+
+		// Planning must leave the local files alone.
+		for _, l := range locals {
+			if _, err := os.Stat(l.path); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestCheckSyncDeletes(t *testing.T) {
+	defer func(f bool, max float64) { force, maxDeletePercent = f, max }(force, maxDeletePercent)
+	maxDeletePercent = 10
+
+	tests := []struct {
+		deletes int
+		known   int
+		force   bool
+		wantErr bool
+	}{
+		{deletes: 1, known: 2},
+		{deletes: syncMinDeletes, known: syncMinDeletes},
+		{deletes: 11, known: 200},
+		{deletes: 11, known: 100, wantErr: true},
+		{deletes: 11, known: 100, force: true},
+		{deletes: 0, known: 0},
+	}
+
+	for _, tt := range tests {
+		force = tt.force
+		err := checkSyncDeletes(tt.deletes, tt.known)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkSyncDeletes(%d, %d) with force %v: got %v", tt.deletes, tt.known, tt.force, err)
+		}
+	}
+}
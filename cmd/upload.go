@@ -5,163 +5,426 @@ import (
 	"fmt"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
 	"github.com/dustin/go-humanize"
-	"github.com/mitchellh/ioprogress"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
-	"time"
+	"sort"
+	"strings"
 )
 
 const chunkSize int64 = 1 << 24
 
-func uploadChunkedFile(dbx files.Client, r io.Reader, commitInfo *files.CommitInfo, sizeTotal int64) (err error) {
-	res, err := dbx.UploadSessionStart(files.NewUploadSessionStartArg(),
-		&io.LimitedReader{R: r, N: chunkSize})
-	if err != nil {
-		return
+// sessionGone reports whether err means the recorded upload session can not
+// be continued and the upload has to start over.
+func sessionGone(err error) bool {
+	e, ok := err.(files.UploadSessionAppendV2APIError)
+	if !ok || e.EndpointError == nil {
+		return false
 	}
+	return e.EndpointError.Tag == files.UploadSessionLookupErrorNotFound ||
+		e.EndpointError.Tag == files.UploadSessionLookupErrorClosed
+}
 
-	written := chunkSize
+// correctOffset returns the offset the server expects when err is an
+// incorrect_offset error.
+func correctOffset(err error) (int64, bool) {
+	e, ok := err.(files.UploadSessionAppendV2APIError)
+	if !ok || e.EndpointError == nil || e.EndpointError.IncorrectOffset == nil {
+		return 0, false
+	}
+	return int64(e.EndpointError.IncorrectOffset.CorrectOffset), true
+}
 
-	for (sizeTotal - written) > chunkSize {
-		args := files.NewUploadSessionCursor(res.SessionId, uint64(written))
-		err = dbx.UploadSessionAppend(args, &io.LimitedReader{R: r, N: chunkSize})
-		if err != nil {
-			return
-		}
+// uploadSessionFile uploads the contents of f into a closed upload session
+// and returns the cursor to commit it with, and the content hash of what it
+// read, which is "" when the upload was resumed. Sessions of more than one chunk
+// are written to the journal under src, so an interrupted upload of the
+// same, unchanged file continues where Dropbox says it stopped.
+func uploadSessionFile(dbx files.Client, f *os.File, src string, dst string, info os.FileInfo) (cursor *files.UploadSessionCursor, hash string, err error) {
+	sizeTotal := info.Size()
+	name := path.Base(src)
+	session, resumed := journal.lookup(src, dst, info)
+	if resumed && session.Offset > 0 {
+		logf(os.Stdout, "Resuming %s at %s\n", name, humanize.IBytes(uint64(session.Offset)))
+	}
 
-		written += chunkSize
+	t := display.begin(name, sizeTotal, session.Offset)
+	defer t.end()
+	hashed := &hashingReader{r: f, h: newContentHasher()}
+	r := t.reader(throttle(hashed))
+
+	// Every attempt seeks f back to the start of its chunk, so a retry only
+	// resends that chunk.
+	seek := func(offset int64) error {
+		_, err := f.Seek(offset, io.SeekStart)
+		hashed.pos = offset
+		t.set(offset)
+		return err
 	}
 
-	cursor := files.NewUploadSessionCursor(res.SessionId, uint64(written))
-	args := files.NewUploadSessionFinishArg(cursor, commitInfo)
+	if !resumed {
+		arg := files.NewUploadSessionStartArg()
+		arg.Close = sizeTotal <= chunkSize
 
-	if _, err = dbx.UploadSessionFinish(args, r); err != nil {
-		return
+		var res *files.UploadSessionStartResult
+		err = retry("upload "+name, func() (err error) {
+			if err = seek(0); err != nil {
+				return
+			}
+			res, err = dbx.UploadSessionStart(arg, &io.LimitedReader{R: r, N: chunkSize})
+			return
+		})
+		if err != nil {
+			return
+		}
+
+		session = uploadSession{
+			SessionID: res.SessionId,
+			Offset:    chunkSize,
+			Dst:       dst,
+			Size:      sizeTotal,
+			ModTime:   info.ModTime(),
+		}
+		if sizeTotal <= chunkSize {
+			// A single chunk is as quick to upload again as to resume.
+			session.Offset = sizeTotal
+		} else if err = journal.record(src, session); err != nil {
+			return
+		}
 	}
 
-	return
-}
+	// The journal is written when the session starts and when all of it is
+	// uploaded, not after every chunk. A resumed upload that starts from an
+	// older offset is moved on by the incorrect_offset error.
+	appended := false
 
-func checkDirExists(dbx files.Client, dst string) (err error) {
-	arg := files.NewListFolderArg(dst)
+	for session.Offset < sizeTotal {
+		n := sizeTotal - session.Offset
+		if n > chunkSize {
+			n = chunkSize
+		}
 
-	_, err = dbx.ListFolder(arg)
-	if err != nil {
-		switch e := err.(type) {
-		case files.ListFolderAPIError:
-			if e.EndpointError.Path.Tag == files.LookupErrorNotFound {
-				arg := files.NewCreateFolderArg(dst)
-				if _, err = dbx.CreateFolder(arg); err != nil {
+		args := files.NewUploadSessionAppendArg(files.NewUploadSessionCursor(session.SessionID, uint64(session.Offset)))
+		args.Close = session.Offset+n == sizeTotal
+		err = retry("upload "+name, func() error {
+			if err := seek(session.Offset); err != nil {
+				return err
+			}
+			return dbx.UploadSessionAppendV2(args, &io.LimitedReader{R: r, N: n})
+		})
+		if err != nil {
+			if offset, ok := correctOffset(err); ok {
+				session.Offset = offset
+				continue
+			}
+			if resumed && sessionGone(err) {
+				if err = journal.forget(src); err != nil {
 					return
 				}
+				t.end()
+				return uploadSessionFile(dbx, f, src, dst, info)
 			}
-		default:
-			return err
+			return
 		}
+
+		session.Offset += n
+		appended = true
 	}
-	return
+	if appended {
+		if err = journal.record(src, session); err != nil {
+			return
+		}
+	}
+
+	hash = hashed.h.sum()
+	if hashed.h.offset != sizeTotal {
+		hash = ""
+	}
+	return files.NewUploadSessionCursor(session.SessionID, uint64(sizeTotal)), hash, nil
 }
 
-func fileExists(dbx files.Client, dst string) bool {
-	arg := files.NewGetMetadataArg(dst)
+// dirExists reports whether the folder dst exists in Dropbox.
+func dirExists(dbx files.Client, dst string) (bool, error) {
+	if dst == "/" || dst == "" {
+		return true, nil
+	}
 
-	if _, err := dbx.GetMetadata(arg); err != nil {
-		return false
+	arg := files.NewListFolderArg(dst)
+
+	err := retry("list "+dst, func() (err error) {
+		_, err = dbx.ListFolder(arg)
+		return
+	})
+	if e, ok := err.(files.ListFolderAPIError); ok && e.EndpointError.Path.Tag == files.LookupErrorNotFound {
+		return false, nil
 	}
-	return true
+	return err == nil, err
 }
 
-func uploadFile(src string, dst string) (err error) {
+func checkDirExists(dbx files.Client, dst string) (err error) {
+	exists, err := dirExists(dbx, dst)
+	if exists || err != nil {
+		return
+	}
+
+	arg := files.NewCreateFolderArg(dst)
+	return retry("create "+dst, func() (err error) {
+		_, err = dbx.CreateFolder(arg)
+		// A folder in the way was most likely created by an earlier attempt
+		// whose response got lost.
+		if e, ok := err.(files.CreateFolderAPIError); ok && e.EndpointError != nil && e.EndpointError.Path != nil &&
+			e.EndpointError.Path.Tag == files.WriteErrorConflict && e.EndpointError.Path.Conflict != nil &&
+			e.EndpointError.Path.Conflict.Tag == files.WriteConflictErrorFolder {
+			return nil
+		}
+		return
+	})
+}
+
+// stagedFile is a file whose contents are uploaded and which is waiting to
+// be committed by commitBatch.
+type stagedFile struct {
+	task   uploadTask
+	key    string
+	hash   string
+	cursor *files.UploadSessionCursor
+	commit *files.CommitInfo
+}
+
+// stageFile uploads the contents of src for dst. skipped is set when the
+// file was left alone because of what is already in Dropbox; same is then
+// the remote file with identical content, if any.
+func stageFile(src string, dst string) (staged *stagedFile, same *remoteFile, skipped bool, err error) {
 	contents, err := os.Open(src)
-	defer contents.Close()
 	if err != nil {
 		return
 	}
+	defer contents.Close()
 
 	contentsInfo, err := contents.Stat()
 	if err != nil {
 		return
 	}
 
-	progressbar := &ioprogress.Reader{
-		Reader: contents,
-		DrawFunc: ioprogress.DrawTerminalf(os.Stderr, func(progress, total int64) string {
-			return fmt.Sprintf("Uploading %s %s/%s", path.Base(src), humanize.IBytes(uint64(progress)), humanize.IBytes(uint64(total)))
-		}),
-		Size: contentsInfo.Size(),
-	}
-
 	commitInfo := files.NewCommitInfo(dst)
-	commitInfo.Mode.Tag = "overwrite"
 
-	commitInfo.ClientModified = time.Now().UTC().Round(time.Second)
+	if commitInfo.ClientModified, err = clientModified(src, contentsInfo); err != nil {
+		return
+	}
 
-	dbx := files.New(config)
+	same, skipped, err = resolveConflict(src, contentsInfo, commitInfo)
+	if skipped || err != nil {
+		return
+	}
 
-	err = checkDirExists(dbx, path.Dir(dst))
+	key, err := filepath.Abs(src)
 	if err != nil {
 		return
 	}
 
-	if fileExists(dbx, dst) {
-		fmt.Printf("%s exists!\n", path.Base(src))
+	// The hash is compared with the one Dropbox returns on commit. It comes
+	// from the upload's own reads unless they did not cover the whole file.
+	cursor, hash, err := uploadSessionFile(files.New(config), contents, key, dst, contentsInfo)
+	if err != nil {
 		return
 	}
+	if hash == "" {
+		if hash, err = fileContentHash(src); err != nil {
+			return
+		}
+	}
 
-	if contentsInfo.Size() > chunkSize {
-		return uploadChunkedFile(dbx, progressbar, commitInfo, contentsInfo.Size())
+	staged = &stagedFile{
+		task:   uploadTask{src: src, dst: dst},
+		key:    key,
+		hash:   hash,
+		cursor: cursor,
+		commit: commitInfo,
 	}
+	return
+}
 
-	if _, err = dbx.Upload(commitInfo, progressbar); err != nil {
-		return
+// uploadTask pairs a local file with the Dropbox path it is uploaded to.
+type uploadTask struct {
+	src string
+	dst string
+}
+
+// remotePath maps a file below src to its Dropbox path below dst, keeping
+// the relative directory structure.
+func remotePath(src string, dst string, file string) (string, error) {
+	rel, err := filepath.Rel(src, file)
+	if err != nil {
+		return "", err
 	}
+	return path.Join(dst, filepath.ToSlash(rel)), nil
+}
 
-	return
+// flatPath returns dir/base, appending " (1)", " (2)", ... before the
+// extension when that path was already handed out.
+func flatPath(seen map[string]bool, dir string, base string) string {
+	name := path.Join(dir, base)
+	ext := path.Ext(base)
+	for i := 1; seen[strings.ToLower(name)]; i++ {
+		name = path.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(base, ext), i, ext))
+	}
+	seen[strings.ToLower(name)] = true
+	return name
 }
 
-func uploadDir(src string, dst string) (err error) {
-	var files []string
-	err = filepath.Walk(src, func(filepath string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			files = append(files, filepath)
+// templateDir expands the destination template dst for file.
+func templateDir(dst string, file string) (string, error) {
+	c, err := readCapture(file)
+	if err != nil {
+		return "", err
+	}
+	return expandTemplate(dst, c), nil
+}
+
+func uploadTasks(src string, dst string) (tasks []uploadTask, err error) {
+	sel, err := newSelector(src)
+	if err != nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	err = filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-		return err
+		skip, err := sel.skip(file, info)
+		if err != nil {
+			return err
+		}
+		if skip && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if skip || info.IsDir() {
+			return nil
+		}
+
+		var remote string
+		switch {
+		case isTemplate(dst):
+			dir, err := templateDir(dst, file)
+			if err != nil {
+				return err
+			}
+			remote = flatPath(seen, dir, filepath.Base(file))
+		case flatten:
+			remote = flatPath(seen, dst, filepath.Base(file))
+		default:
+			if remote, err = remotePath(src, dst, file); err != nil {
+				return err
+			}
+		}
+		tasks = append(tasks, uploadTask{src: file, dst: remote})
+		return nil
 	})
+	return
+}
 
-	if len(files) > 1 {
-		maxChannels := 4
-		if len(files) < maxChannels {
-			maxChannels = len(files)
-		}
-		var channels []chan string
-		chanOut := make(chan int)
-		for i := 0; i < maxChannels; i++ {
-			ch := make(chan string)
-			channels = append(channels, ch)
-			go func(index int) {
-				for {
-					filepath := <-ch
-					uploadFile(filepath, dst+path.Base(filepath))
-					chanOut <- index
-				}
-			}(i)
-			ch <- files[i]
+// remoteDirs returns the parent folders of tasks, sorted.
+func remoteDirs(tasks []uploadTask) []string {
+	dirs := make(map[string]bool)
+	for _, t := range tasks {
+		dirs[path.Dir(t.dst)] = true
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// createRemoteDirs makes sure every parent folder of tasks exists, calling
+// checkDirExists once per folder.
+func createRemoteDirs(dbx files.Client, tasks []uploadTask) (err error) {
+	for _, dir := range remoteDirs(tasks) {
+		if err = checkDirExists(dbx, dir); err != nil {
+			return
 		}
+	}
+	return
+}
+
+// prepareDir lists the files below src and indexes what is already in the
+// folders they go to, so that the uploads need no metadata call per file.
+// With --match-anywhere the index covers everything below the destination
+// root instead.
+func prepareDir(src string, dst string) (tasks []uploadTask, err error) {
+	tasks, err = uploadTasks(src, dst)
+	if err != nil || len(tasks) == 0 {
+		return
+	}
 
-		for _, f := range files[maxChannels:] {
-			select {
-			case chanIndex := <-chanOut:
-				channels[chanIndex] <- f
+	all := len(tasks)
+	if tasks, err = newOnCard(tasks); err != nil {
+		return
+	}
+	if n := all - len(tasks); n > 0 {
+		logf(os.Stderr, "%d files already imported from this card, use --reimport to check them again\n", n)
+	}
+	if len(tasks) == 0 {
+		return
+	}
+
+	var entries []*remoteFile
+	if matchAnywhere {
+		if entries, err = listRemoteFiles(templateRoot(dst)); err != nil {
+			return
+		}
+	} else {
+		for _, dir := range remoteDirs(tasks) {
+			dirEntries, err := listDir(dir)
+			if err != nil {
+				return nil, err
 			}
+			entries = append(entries, dirEntries...)
+		}
+	}
+	remote = newRemoteIndex(entries)
+	remote.matchMoved = true
+	return
+}
+
+// prepareFile returns the task for uploading the single file src into dst.
+func prepareFile(src string, dst string) (tasks []uploadTask, err error) {
+	if isTemplate(dst) {
+		if dst, err = templateDir(dst, src); err != nil {
+			return
 		}
+	}
+	return []uploadTask{{src: src, dst: path.Join(dst, path.Base(src))}}, nil
+}
 
-	} else {
-		filepath := files[0]
-		return uploadFile(filepath, dst+path.Base(filepath))
+// checkUploadFlags validates the flags shared by upload and watch and sets
+// up the filters they select.
+func checkUploadFlags() error {
+	if !validConflictPolicy(onConflict) {
+		return fmt.Errorf("unknown --on-conflict policy %q", onConflict)
+	}
+	if clientModifiedFrom != modifiedFromMtime && clientModifiedFrom != modifiedFromCapture {
+		return fmt.Errorf("--client-modified must be %s or %s", modifiedFromMtime, modifiedFromCapture)
 	}
+
+	var err error
+	filters, err = newFileFilter()
+	return err
+}
+
+// openUploadState loads the session journal and the upload catalogue.
+func openUploadState() (err error) {
+	dir, err := configDir()
+	if err != nil {
+		return
+	}
+	journal = openJournal(path.Join(dir, journalFileName))
+	uploads = &catalogue{filePath: path.Join(dir, catalogueFileName)}
 	return
 }
 
@@ -169,26 +432,68 @@ func upload(cmd *cobra.Command, args []string) (err error) {
 	if len(args) == 0 || len(args) > 2 {
 		return errors.New("`upload` requires `src` and/or `dst` arguments")
 	}
-
-	src := args[0]
-	srcInfo, err := os.Stat(src)
-	if os.IsNotExist(err) {
+	if err = checkUploadFlags(); err != nil {
+		return
+	}
+	if err = openUploadState(); err != nil {
 		return
 	}
 
-	dst := "/"
+	dst := defaultDst()
 	if len(args) == 2 {
 		dst = args[1]
 	}
+	return uploadSource(args[0], dst)
+}
 
+// uploadSource runs the upload pipeline for the file or directory src.
+func uploadSource(src string, dst string) (err error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return
+	}
+	currentCard = identifyCard(src)
+	remote = nil
+
+	var tasks []uploadTask
 	if srcInfo.IsDir() {
-		return uploadDir(src, dst)
+		tasks, err = prepareDir(src, dst)
 	} else {
-		dst := dst + path.Base(src)
-		return uploadFile(src, dst)
+		tasks, err = prepareFile(src, dst)
+	}
+	if err != nil || len(tasks) == 0 {
+		return
+	}
+
+	if dryRun {
+		plan, err := planUpload(tasks)
+		if err != nil {
+			return err
+		}
+		return plan.print()
+	}
+
+	if deleteAfterUpload {
+		sweeper = &cleanup{}
+		if srcInfo.IsDir() {
+			sweeper.root = src
+		}
+		defer func() {
+			sweeper.pruneDirs()
+			sweeper.summary()
+		}()
+	}
+
+	if err = createRemoteDirs(files.New(config), tasks); err != nil {
+		return
 	}
+
+	_, err = uploadAll(tasks)
+	return
 }
 
+var flatten bool
+
 var uploadCmd = &cobra.Command{
 	Use:   "upload",
 	Short: "Upload files",
@@ -196,5 +501,17 @@ var uploadCmd = &cobra.Command{
 }
 
 func init() {
+	addFilterFlags(uploadCmd)
+	uploadCmd.Flags().BoolVar(&reimport, "reimport", false, "also consider files already imported from this card")
+	uploadCmd.Flags().BoolVar(&flatten, "flatten", false, "upload every file directly into dst, renaming files whose names collide")
+	uploadCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "number of files to upload in parallel")
+	uploadCmd.Flags().StringVar(&onConflict, "on-conflict", conflictRename, "what to do when a different file exists at the destination: skip, rename, overwrite or fail")
+	uploadCmd.Flags().BoolVar(&matchAnywhere, "match-anywhere", false, "skip files whose content is anywhere below the destination root, not just in the folder they go to")
+	uploadCmd.Flags().BoolVar(&deleteAfterUpload, "delete-after-upload", false, "remove local files once their content is verified in Dropbox")
+	uploadCmd.Flags().IntVar(&keepDays, "keep-days", 0, "with --delete-after-upload, keep files modified in the last N days")
+	uploadCmd.Flags().StringVar(&clientModifiedFrom, "client-modified", modifiedFromMtime, "time shown as modified in Dropbox: mtime or capture (Exif/video capture time)")
+	uploadCmd.Flags().DurationVar(&cameraClockOffset, "camera-clock-offset", 0, "correct the camera clock by this much, e.g. -1h or 9h30m")
+	uploadCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "print what would be uploaded without changing anything")
+	uploadCmd.Flags().BoolVar(&planJSON, "json", false, "with --dry-run, print the plan as JSON")
 	RootCmd.AddCommand(uploadCmd)
 }
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// verifyReport counts the discrepancies found by `boxit verify`.
+type verifyReport struct {
+	matched      int
+	missing      int
+	extra        int
+	sizeMismatch int
+	hashMismatch int
+}
+
+func (r *verifyReport) discrepancies() int {
+	return r.missing + r.extra + r.sizeMismatch + r.hashMismatch
+}
+
+func verifyRun(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 2 {
+		return errors.New("`verify` requires `local` and `remote` arguments")
+	}
+	src, dst := args[0], path.Clean("/"+args[1])
+
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return
+	}
+
+	var tasks []uploadTask
+	var entries []*remoteFile
+	if srcInfo.IsDir() {
+		if tasks, err = uploadTasks(src, dst); err != nil {
+			return
+		}
+		if entries, err = listRemoteFiles(dst); err != nil {
+			return
+		}
+	} else {
+		tasks = []uploadTask{{src: src, dst: dst}}
+		file, err := getRemoteFile(dst)
+		if err != nil {
+			return err
+		}
+		if file != nil && file.Tag == "file" {
+			entries = []*remoteFile{file}
+		}
+	}
+	index := newRemoteIndex(entries)
+
+	var report verifyReport
+	seen := make(map[string]bool)
+	for _, t := range tasks {
+		seen[strings.ToLower(t.dst)] = true
+
+		existing := index.file(t.dst)
+		if existing == nil {
+			fmt.Printf("missing  %s\n", t.src)
+			report.missing++
+			continue
+		}
+
+		info, err := os.Stat(t.src)
+		if err != nil {
+			return err
+		}
+		if uint64(info.Size()) != existing.Size {
+			fmt.Printf("size     %s: %d bytes locally, %d in Dropbox\n", t.src, info.Size(), existing.Size)
+			report.sizeMismatch++
+			continue
+		}
+
+		hash, err := fileContentHash(t.src)
+		if err != nil {
+			return err
+		}
+		if hash != existing.ContentHash {
+			fmt.Printf("hash     %s: content differs from %s\n", t.src, existing.PathDisplay)
+			report.hashMismatch++
+			continue
+		}
+		report.matched++
+	}
+
+	if srcInfo.IsDir() {
+		var extra []string
+		for _, e := range entries {
+			if !seen[e.PathLower] {
+				extra = append(extra, e.PathDisplay)
+			}
+		}
+		sort.Strings(extra)
+		for _, e := range extra {
+			fmt.Printf("extra    %s\n", e)
+		}
+		report.extra = len(extra)
+	}
+
+	fmt.Printf("%d verified, %d missing, %d extra, %d size mismatches, %d hash mismatches\n",
+		report.matched, report.missing, report.extra, report.sizeMismatch, report.hashMismatch)
+	if n := report.discrepancies(); n > 0 {
+		return fmt.Errorf("%d discrepancies between %s and %s", n, src, dst)
+	}
+	return
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <local> <remote>",
+	Short: "Check that a Dropbox folder matches a local directory",
+	RunE:  verifyRun,
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+// Where desktop systems and fstab entries usually mount removable media.
+var mediaDirs = []string{"/media/", "/run/media/", "/mnt/"}
+
+var watchInterval time.Duration
+
+// unescapeMount decodes the octal escapes (\040 for a space) the kernel
+// uses in mountinfo.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// mountedMedia returns the mount points below the usual removable media
+// directories.
+func mountedMedia() (mounts map[string]bool, err error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	mounts = make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mount := unescapeMount(fields[4])
+		for _, dir := range mediaDirs {
+			if strings.HasPrefix(mount, dir) {
+				mounts[mount] = true
+				break
+			}
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// notify tells the user about a card, on the desktop if notify-send is
+// installed.
+func notify(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), msg)
+	if bin, err := exec.LookPath("notify-send"); err == nil {
+		exec.Command(bin, "boxit", msg).Run()
+	}
+}
+
+// importCard uploads the DCIM folder of the card mounted at mount.
+func importCard(mount string, dst string) {
+	dcim := filepath.Join(mount, "DCIM")
+	notify("importing %s", mount)
+	if err := uploadSource(dcim, dst); err != nil {
+		notify("import of %s failed: %v", mount, err)
+		return
+	}
+	notify("%s imported, the card can be removed", mount)
+}
+
+func watch(cmd *cobra.Command, args []string) (err error) {
+	dst := profile.Dst
+	if len(args) == 1 {
+		dst = args[0]
+	}
+	if len(args) > 1 || dst == "" {
+		return errors.New("`watch` requires a `dst` argument")
+	}
+	if err = checkUploadFlags(); err != nil {
+		return
+	}
+	if err = openUploadState(); err != nil {
+		return
+	}
+
+	fmt.Printf("waiting for cards below %s\n", strings.Join(mediaDirs, ", "))
+
+	// Cards stay in seen until they are unmounted, so that each one is
+	// imported once per insertion.
+	seen := make(map[string]bool)
+	for {
+		mounts, err := mountedMedia()
+		if err != nil {
+			return err
+		}
+
+		for mount := range seen {
+			if !mounts[mount] {
+				delete(seen, mount)
+			}
+		}
+
+		for mount := range mounts {
+			if seen[mount] {
+				continue
+			}
+			seen[mount] = true
+
+			if info, err := os.Stat(filepath.Join(mount, "DCIM")); err == nil && info.IsDir() {
+				importCard(mount, dst)
+			}
+		}
+
+		time.Sleep(watchInterval)
+	}
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [dst]",
+	Short: "Upload camera cards as soon as they are mounted",
+	Long:  "Watch for camera cards (volumes with a DCIM folder) being mounted below /media, /run/media or /mnt and upload them to dst, which may be a template like /Photos/{yyyy}/{yyyy-mm-dd}. dst defaults to the one of the profile.",
+	RunE:  watch,
+}
+
+func init() {
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 2*time.Second, "how often to check for newly mounted cards")
+	watchCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "number of files to upload in parallel")
+	watchCmd.Flags().StringVar(&onConflict, "on-conflict", conflictRename, "what to do when a different file exists at the destination: skip, rename, overwrite or fail")
+	watchCmd.Flags().BoolVar(&matchAnywhere, "match-anywhere", false, "skip files whose content is anywhere below the destination root, not just in the folder they go to")
+	watchCmd.Flags().StringVar(&clientModifiedFrom, "client-modified", modifiedFromMtime, "time shown as modified in Dropbox: mtime or capture (Exif/video capture time)")
+	watchCmd.Flags().BoolVar(&deleteAfterUpload, "delete-after-upload", false, "remove local files once their content is verified in Dropbox")
+	watchCmd.Flags().IntVar(&keepDays, "keep-days", 0, "with --delete-after-upload, keep files modified in the last N days")
+	addFilterFlags(watchCmd)
+	RootCmd.AddCommand(watchCmd)
+}